@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/caarlos0/log"
+	"github.com/dyammarcano/persistent-container/internal/container"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <schema> <bucket>",
+	Short: "Rewrite every value of a bucket to the latest schema version",
+	Long: `Rewrite every value of a bucket that was written with an older version of a
+registered container schema, running the schema migrations on each one.
+
+Schemas are registered with container.RegisterSchema by the code built into this
+binary, the command only knows the schemas registered before it runs. Register them
+in an init function of a package imported by main, for example:
+
+	func init() {
+		container.RegisterSchema[Order]("order", migrateOrderV1)
+	}`,
+	Args: cobra.ExactArgs(2),
+	RunE: migrate,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func migrate(cmd *cobra.Command, args []string) error {
+	schemaName, bucketName := args[0], args[1]
+
+	names := container.SchemaNames()
+	if len(names) == 0 {
+		return fmt.Errorf("no schemas registered, they are registered with container.RegisterSchema in the packages built into this binary")
+	}
+	log.Infof("registered schemas: %s", strings.Join(names, ", "))
+
+	databasePath, err := filepath.Abs(viper.GetString("database"))
+	if err != nil {
+		return err
+	}
+	log.Infof("database path is %s", databasePath)
+
+	db, err := store.NewStore(cmd.Context(), databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrated, err := container.MigrateSchema(db, schemaName, bucketName)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("migrated %d values of bucket %s to schema %s", migrated, bucketName, schemaName)
+	return nil
+}
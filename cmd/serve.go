@@ -8,7 +8,9 @@ import (
 	"github.com/dyammarcano/persistent-container/internal/store"
 	"github.com/spf13/viper"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -39,7 +41,10 @@ func server(cmd *cobra.Command, args []string) error {
 	databasePath := viper.GetString("database")
 	log.Infof("database path is %s", databasePath)
 
-	ctx := context.Background()
+	// cancelled on SIGINT/SIGTERM so the server stops and the store flushes every open container
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	databasePath, err := filepath.Abs(databasePath)
 	if err != nil {
 		return err
@@ -63,5 +68,7 @@ func server(cmd *cobra.Command, args []string) error {
 
 	newMonitoring.StartServer(callback)
 
-	return nil
+	log.Info("flushing containers and closing database")
+
+	return db.Close()
 }
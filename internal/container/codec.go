@@ -0,0 +1,124 @@
+package container
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"sync"
+)
+
+const (
+	CodecCBOR byte = iota + 1
+	CodecJSON
+	CodecGob
+)
+
+var (
+	// CBOR encodes values as canonical cbor, the default codec
+	CBOR Codec = cborCodec{}
+	// JSON encodes values as json, stored values stay readable by json tools
+	JSON Codec = jsonCodec{}
+	// Gob encodes values with encoding/gob
+	Gob Codec = gobCodec{}
+
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		CodecCBOR: CBOR,
+		CodecJSON: JSON,
+		CodecGob:  Gob,
+	}
+)
+
+type (
+	// Codec encodes the objects of containers and collections, the id is stored with every
+	// value so it is decoded with the same codec it was written with
+	Codec interface {
+		ID() byte
+		Name() string
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+
+	cborCodec struct{}
+	jsonCodec struct{}
+	gobCodec  struct{}
+)
+
+// RegisterCodec makes a user codec available for decoding stored values, ids below 128
+// are reserved for built-in codecs
+func RegisterCodec(codec Codec) error {
+	if codec.ID() < 128 {
+		return fmt.Errorf("codec id %d of %s is reserved", codec.ID(), codec.Name())
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if registered, ok := codecs[codec.ID()]; ok {
+		return fmt.Errorf("codec id %d already registered by %s", codec.ID(), registered.Name())
+	}
+
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+// unregisterCodec removes a user codec
+func unregisterCodec(id byte) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if id >= 128 {
+		delete(codecs, id)
+	}
+}
+
+// lookupCodec returns the registered codec with the given id
+func lookupCodec(id byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec id %d", id)
+	}
+	return codec, nil
+}
+
+func (cborCodec) ID() byte     { return CodecCBOR }
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return encMode.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+func (jsonCodec) ID() byte     { return CodecJSON }
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (gobCodec) ID() byte     { return CodecGob }
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,88 @@
+package container
+
+import (
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type upperCodec struct{}
+
+func (upperCodec) ID() byte     { return 200 }
+func (upperCodec) Name() string { return "upper" }
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(*testStruct).Name)), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	v.(*testStruct).Name = string(data)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	db := newTestStore(t)
+
+	for _, codec := range []Codec{CBOR, JSON, Gob} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			container := NewContainer[testStruct](testStruct{Name: "John", Age: 42}, codec.Name(), "testKey", db,
+				WithMode(ModeExplicit), WithCodec(codec))
+			assert.NoErrorf(t, container.Save(), "error saving container")
+
+			loaded, err := LoadContainer[testStruct](db, codec.Name(), "testKey", WithMode(ModeExplicit))
+			assert.NoErrorf(t, err, "error loading container")
+			assert.Equal(t, testStruct{Name: "John", Age: 42}, *loaded.GetObject())
+		})
+	}
+}
+
+func TestCodecJSONReadable(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db, WithMode(ModeExplicit), WithCodec(JSON))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	data, err := db.Get("test", "testKey")
+	assert.NoErrorf(t, err, "error getting key")
+
+	var stored struct {
+		Codec string     `json:"codec"`
+		Data  testStruct `json:"data"`
+	}
+	assert.NoErrorf(t, json.Unmarshal(data, &stored), "stored value is not json")
+	assert.Equal(t, "json", stored.Codec)
+	assert.Equal(t, "John", stored.Data.Name)
+}
+
+func TestCodecLegacyCBOR(t *testing.T) {
+	db := newTestStore(t)
+
+	data, err := cbor.Marshal(testStruct{Name: "John"})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("test", "legacyKey", data))
+
+	loaded, err := LoadContainer[testStruct](db, "test", "legacyKey", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading value without envelope")
+	assert.Equal(t, "John", loaded.GetObject().Name)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Error(t, RegisterCodec(jsonCodec{}), "built-in ids are reserved")
+	assert.NoError(t, RegisterCodec(upperCodec{}))
+	t.Cleanup(func() {
+		unregisterCodec(upperCodec{}.ID())
+	})
+
+	assert.Error(t, RegisterCodec(upperCodec{}), "id already registered")
+
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db, WithMode(ModeExplicit), WithCodec(upperCodec{}))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	loaded, err := LoadContainer[testStruct](db, "test", "testKey", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, "JOHN", loaded.GetObject().Name)
+}
@@ -0,0 +1,208 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	tagName    = "pc"
+	tagKey     = "key"
+	tagEncrypt = "encrypt"
+)
+
+// ErrKeyExists is returned when inserting an object whose key is already stored
+var ErrKeyExists = errors.New("key already exists")
+
+type (
+	// Collection stores many objects of the same type in one bucket, each one under its own key,
+	// the key is taken from the string field tagged `pc:"key"` or generated with store.GenerateKey
+	Collection[T any] struct {
+		db         *store.Store
+		bucketName string
+		keyField   []int
+		opts       options
+		mu         sync.Mutex
+	}
+)
+
+// NewCollection creates a collection over bucketName, only the codec option applies to collections
+func NewCollection[T any](db *store.Store, bucketName string, opts ...Option) *Collection[T] {
+	c := &Collection[T]{
+		db:         db,
+		bucketName: bucketName,
+		keyField:   keyFieldIndex(reflect.TypeFor[T]()),
+		opts:       defaultOptions(),
+		mu:         sync.Mutex{},
+	}
+
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+
+	return c
+}
+
+// keyFieldIndex returns the index of the string field tagged as key, nil if there is none
+func keyFieldIndex(t reflect.Type) []int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if field.IsExported() && field.Type.Kind() == reflect.String && hasTag(field, tagKey) {
+			return field.Index
+		}
+	}
+	return nil
+}
+
+// hasTag reports whether the pc tag of the field holds the option
+func hasTag(field reflect.StructField, option string) bool {
+	for _, opt := range strings.Split(field.Tag.Get(tagName), ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the key of the object, generating and setting one when the key field is empty
+func (c *Collection[T]) key(obj *T) string {
+	if c.keyField == nil {
+		return store.GenerateKey()
+	}
+
+	field := reflect.ValueOf(obj).Elem().FieldByIndex(c.keyField)
+	if field.String() == "" {
+		field.SetString(store.GenerateKey())
+	}
+	return field.String()
+}
+
+// get reads and decodes the object stored under id and returns its revision, the caller must hold the lock
+func (c *Collection[T]) get(id string) (*T, uint64, error) {
+	data, rev, err := c.db.GetWithRevision(c.bucketName, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(data) == 0 {
+		return nil, 0, &NotFoundError{Bucket: c.bucketName, Key: id}
+	}
+
+	var obj T
+	if _, err = unmarshalValue(data, &obj, location{c.bucketName, id}); err != nil {
+		return nil, 0, err
+	}
+	return &obj, rev, nil
+}
+
+// put validates, encodes and writes the object under id if the key is still at the expected
+// revision, a *store.ConflictError is returned otherwise. The caller must hold the lock
+func (c *Collection[T]) put(id string, obj *T, expected uint64) error {
+	if err := validation.Validate(obj); err != nil {
+		return err
+	}
+
+	data, err := marshalValue(c.opts.codec, obj, location{c.bucketName, id})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.PutIfRevision(c.bucketName, id, data, expected)
+	return err
+}
+
+// Insert stores a new object and returns its key, an error matching ErrKeyExists is returned
+// if the key is already stored, it wraps the *store.ConflictError when the key was written
+// by someone else during the insert
+func (c *Collection[T]) Insert(obj T) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.key(&obj)
+
+	// keys written before revisions were kept are at revision 0 as well
+	data, err := c.db.Get(c.bucketName, id)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrKeyExists, id)
+	}
+
+	err = c.put(id, &obj, 0)
+	if errors.Is(err, store.ErrConflict) {
+		return "", fmt.Errorf("%w: %w", ErrKeyExists, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Get returns the object stored under id, a *NotFoundError is returned if it doesn't exist
+func (c *Collection[T]) Get(id string) (*T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, _, err := c.get(id)
+	return obj, err
+}
+
+// Update runs fn against the object stored under id and writes the result, nothing is written
+// if fn returns an error. A *store.ConflictError is returned if the object was written by
+// someone else since it was read, fn may then be run again against the new object
+func (c *Collection[T]) Update(id string, fn func(*T) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, rev, err := c.get(id)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(obj); err != nil {
+		return err
+	}
+
+	return c.put(id, obj, rev)
+}
+
+// Delete removes the object stored under id
+func (c *Collection[T]) Delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.db.DeleteKey(c.bucketName, id)
+}
+
+// List returns every object of the collection ordered by key
+func (c *Collection[T]) List() ([]T, error) {
+	keys, values, err := c.db.GetBucketKeysValues(c.bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]T, 0, len(values))
+	for i, value := range values {
+		var obj T
+		if _, err = unmarshalValue(value, &obj, location{c.bucketName, string(keys[i])}); err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// Count returns the number of objects in the collection
+func (c *Collection[T]) Count() (int, error) {
+	return c.db.CountKeys(c.bucketName)
+}
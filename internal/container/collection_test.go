@@ -0,0 +1,80 @@
+package container
+
+import (
+	"errors"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type account struct {
+	ID      string `pc:"key"`
+	Owner   string
+	Balance int
+}
+
+func TestCollection(t *testing.T) {
+	db := newTestStore(t)
+
+	accounts := NewCollection[account](db, "accounts")
+
+	id, err := accounts.Insert(account{ID: "acc-1", Owner: "John", Balance: 10})
+	assert.NoErrorf(t, err, "error inserting account")
+	assert.Equal(t, "acc-1", id)
+
+	generated, err := accounts.Insert(account{Owner: "Helen"})
+	assert.NoErrorf(t, err, "error inserting account")
+	assert.Len(t, generated, 36)
+
+	_, err = accounts.Insert(account{ID: "acc-1"})
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	stored, err := accounts.Get(generated)
+	assert.NoErrorf(t, err, "error getting account")
+	assert.Equal(t, generated, stored.ID, "generated key not set on the object")
+
+	assert.NoErrorf(t, accounts.Update("acc-1", func(a *account) error {
+		a.Balance += 5
+		return nil
+	}), "error updating account")
+
+	assert.Error(t, accounts.Update("acc-1", func(a *account) error {
+		a.Balance = 0
+		return errors.New("abort")
+	}))
+
+	stored, err = accounts.Get("acc-1")
+	assert.NoErrorf(t, err, "error getting account")
+	assert.Equal(t, 15, stored.Balance)
+
+	// another writer updates the account while fn runs
+	other := NewCollection[account](db, "accounts")
+	err = accounts.Update("acc-1", func(a *account) error {
+		a.Balance += 100
+		return other.Update("acc-1", func(a *account) error {
+			a.Balance += 1
+			return nil
+		})
+	})
+	var conflict *store.ConflictError
+	assert.ErrorAs(t, err, &conflict)
+
+	stored, err = accounts.Get("acc-1")
+	assert.NoErrorf(t, err, "error getting account")
+	assert.Equal(t, 16, stored.Balance, "update lost")
+
+	count, err := accounts.Count()
+	assert.NoErrorf(t, err, "error counting accounts")
+	assert.Equal(t, 2, count)
+
+	assert.NoErrorf(t, accounts.Delete("acc-1"), "error deleting account")
+
+	_, err = accounts.Get("acc-1")
+	var notFound *NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+
+	list, err := accounts.List()
+	assert.NoErrorf(t, err, "error listing accounts")
+	assert.Len(t, list, 1)
+	assert.Equal(t, "Helen", list[0].Owner)
+}
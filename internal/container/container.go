@@ -1,74 +1,272 @@
 package container
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
 	"github.com/fxamacker/cbor/v2"
 	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
 	"reflect"
 	"sync"
 	"time"
 )
 
+// ErrClosed is returned when a closed container is updated or saved
+var ErrClosed = errors.New("container closed")
+
+// encMode encodes maps with sorted keys so equal objects always produce the same bytes
+var encMode = func() cbor.EncMode {
+	em, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
 type (
+	// NotFoundError is returned when a container key doesn't exist in the store
+	NotFoundError struct {
+		Bucket string
+		Key    string
+	}
+
+	// Stats describes the state of a container
+	Stats struct {
+		Uid         string    `json:"uid"`
+		Type        string    `json:"type"`
+		Bucket      string    `json:"bucket"`
+		Key         string    `json:"key"`
+		Timestamp   int64     `json:"timestamp"`
+		Mode        Mode      `json:"mode"`
+		Policy      Policy    `json:"policy"`
+		Modified    bool      `json:"modified"`
+		Saved       bool      `json:"saved"`
+		Revision    uint64    `json:"revision"`
+		Saves       int64     `json:"saves"`
+		Errors      int64     `json:"errors"`
+		LastError   error     `json:"-"`
+		LastSavedAt time.Time `json:"last_saved_at"`
+	}
+
 	Container[T any] struct {
 		uid         string
 		timestamp   int64
 		item        T
-		itemClone   T
+		snapshot    []byte
+		observed    []byte
 		bucketName  string
 		key         string
 		db          *store.Store
 		saved       bool
 		modified    bool
-		lasModified int64
+		stalled     bool
+		closed      bool
+		lastErr     error
+		lastSavedAt time.Time
+		saves       int64
+		errors      int64
+		failures    int
+		retryAt     time.Time
+		dirtySince  time.Time
+		timer       *time.Timer
+		watchers    []chan Change[T]
+		revision    uint64
+		seen        uint64
+		unsubscribe func()
 		ctx         context.Context
+		cancel      context.CancelFunc
 		mu          sync.RWMutex
+		opts        options
 	}
 )
 
-func NewContainer[T any](obj T, bucketName string, key string, db *store.Store) *Container[T] {
+// NewContainer creates a container for obj, it replaces the value stored in bucketName/key as of
+// now, writes made to the key by others after that make Save return a *store.ConflictError
+func NewContainer[T any](obj T, bucketName string, key string, db *store.Store, opts ...Option) *Container[T] {
+	c := newContainer[T](obj, bucketName, key, db, opts)
+	_ = c.clone() // snapshot object, an encoding error shows up again on the first save
+
+	// a failed read shows up as a conflict on the first save
+	c.revision, _ = db.GetRevision(bucketName, key)
+	c.seen = c.revision
+	c.start()
+
+	return c
+}
+
+// LoadContainer creates a container from the object already stored in bucketName/key,
+// a *NotFoundError is returned if the key doesn't exist
+func LoadContainer[T any](db *store.Store, bucketName string, key string, opts ...Option) (*Container[T], error) {
+	var obj T
+	c := newContainer[T](obj, bucketName, key, db, opts)
+
+	if err := c.get(); err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	c.saved = true
+	c.start()
+
+	return c, nil
+}
+
+// GetOrCreate loads the container stored in bucketName/key, if the key doesn't exist
+// a new container is created from obj and saved right away
+func GetOrCreate[T any](obj T, bucketName string, key string, db *store.Store, opts ...Option) (*Container[T], error) {
+	c, err := LoadContainer[T](db, bucketName, key, opts...)
+	if err == nil {
+		return c, nil
+	}
+
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	c = newContainer[T](obj, bucketName, key, db, opts)
+
+	if err = c.Save(); err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	c.start()
+
+	return c, nil
+}
+
+func newContainer[T any](obj T, bucketName string, key string, db *store.Store, opts []Option) *Container[T] {
+	ctx, cancel := context.WithCancel(db.Ctx)
+
 	c := &Container[T]{
 		db:         db,
 		item:       obj,
-		ctx:        db.Ctx,
+		ctx:        ctx,
+		cancel:     cancel,
 		bucketName: bucketName,
 		key:        key,
 		mu:         sync.RWMutex{},
 		uid:        uuid.NewString(),
 		timestamp:  time.Now().UnixNano(),
+		opts:       defaultOptions(),
 	}
 
-	c.clone()         // clone object
-	go c.isModified() // start isModified goroutine
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
 
 	return c
 }
 
-// encode encodes the object to cbor
+// start registers the container in the store and starts the isModified goroutine
+// when the container is in polling mode
+func (c *Container[T]) start() {
+	c.db.Track(c.uid, c)
+
+	if c.opts.reload {
+		c.subscribe()
+	}
+
+	if c.opts.mode == ModePolling {
+		go c.isModified()
+	}
+}
+
+// MarshalJSON adds the message of the last error
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type stats Stats
+
+	var lastError string
+	if s.LastError != nil {
+		lastError = s.LastError.Error()
+	}
+
+	return json.Marshal(struct {
+		stats
+		LastError string `json:"last_error,omitempty"`
+	}{stats(s), lastError})
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("key %s not found in bucket %s", e.Key, e.Bucket)
+}
+
+// encode encodes the object to canonical cbor, used for snapshots whatever the codec is
 func (c *Container[T]) encode() ([]byte, error) {
-	return cbor.Marshal(c.item)
+	return encMode.Marshal(c.item)
 }
 
-// decode decodes the object from cbor
+// marshal encodes the object with the container codec for storage
+func (c *Container[T]) marshal() ([]byte, error) {
+	return marshalValue(c.opts.codec, &c.item, location{c.bucketName, c.key})
+}
+
+// decode decodes a stored object with the codec it was written with, a migrated object
+// keeps no snapshot so it is written back in the current schema on the next save
 func (c *Container[T]) decode(data []byte) error {
-	if err := cbor.Unmarshal(data, &c.item); err != nil {
+	var item T
+	migrated, err := unmarshalValue(data, &item, location{c.bucketName, c.key})
+	if err != nil {
+		return err
+	}
+
+	c.item = item
+	if !migrated {
+		return c.clone()
+	}
+
+	c.snapshot = nil
+	c.observed, err = c.encode()
+	return err
+}
+
+// restore replaces the object with the decoded data, nested maps, slices and pointers
+// are decoded into a fresh value so nothing is shared with the previous object
+func (c *Container[T]) restore(data []byte) error {
+	var item T
+	if err := cbor.Unmarshal(data, &item); err != nil {
 		return err
 	}
-	c.clone()
+	c.item = item
 	return nil
 }
 
-// clone clones the object
-func (c *Container[T]) clone() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.itemClone = c.item
+// clone takes a snapshot of the encoded object, the caller must hold the lock
+func (c *Container[T]) clone() error {
+	data, err := c.encode()
+	if err != nil {
+		return err
+	}
+	c.snapshot = data
+	c.observed = data
+	return nil
 }
 
-// isModified checks if the object has been modified
+// changed compares the encoded object against the last snapshot, watchers are notified of a
+// local change when the object differs from its last observed state, the caller must hold the lock
+func (c *Container[T]) changed() (bool, error) {
+	data, err := c.encode()
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(data, c.observed) {
+		c.notify(SourceLocal, c.observed, data)
+		c.observed = data
+	}
+
+	return !bytes.Equal(data, c.snapshot), nil
+}
+
+// isModified checks if the object has been modified every poll interval
 func (c *Container[T]) isModified() {
-	ticker := time.NewTicker(500 * time.Millisecond)
+	ticker := time.NewTicker(c.opts.pollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -81,31 +279,112 @@ func (c *Container[T]) isModified() {
 	}
 }
 
-// checkModified checks if the object has been modified
+// checkModified checks if the object has been modified and persists it according to the
+// write policy, failed writes are retried on later ticks with an exponential backoff
 func (c *Container[T]) checkModified(tick time.Time) {
+	if err := c.persistModified(tick); err != nil && c.opts.onError != nil {
+		c.opts.onError(err) // called without the lock so the handler can use the container
+	}
+}
+
+// persistModified returns the error to report, transient errors are only reported
+// once the retry attempts are exhausted
+func (c *Container[T]) persistModified(tick time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if tick.Unix()-c.lasModified > 1 {
-		c.modified = !reflect.DeepEqual(c.item, c.itemClone)
-		c.lasModified = tick.Unix()
+	if c.closed || tick.Before(c.retryAt) {
+		return nil
+	}
 
-		if c.modified {
-			if err := c.set(); err != nil {
-				return
-			}
+	observed := c.observed
+	modified, err := c.changed()
+	if err != nil {
+		if c.stalled {
+			return nil // already reported
 		}
+		return c.failed(tick, err)
+	}
+
+	if !modified {
+		return nil
 	}
+
+	c.modified = true
+
+	// a write that failed for good is only attempted again once the object changes
+	fresh := !bytes.Equal(observed, c.observed)
+	if c.stalled && !fresh {
+		return nil
+	}
+	c.stalled = false
+
+	if c.opts.policy == WriteThrough {
+		if err = c.set(); err != nil {
+			return c.failed(tick, err)
+		}
+		return nil
+	}
+
+	// a change seen on an earlier tick is already scheduled, scheduling it again would push the
+	// write back to the max delay
+	if fresh {
+		c.persist(tick)
+	}
+
+	return nil
+}
+
+// failed schedules the next attempt after a failed background write, an error that isn't
+// transient stalls the background writes until the object changes again
+func (c *Container[T]) failed(tick time.Time, err error) error {
+	if !isTransient(err) {
+		c.stalled = true
+		return err
+	}
+
+	c.failures++
+
+	backoff := c.opts.backoff << (c.failures - 1)
+	if backoff <= 0 || backoff > c.opts.maxBackoff {
+		backoff = c.opts.maxBackoff
+	}
+	c.retryAt = tick.Add(backoff)
+
+	if c.failures <= c.opts.retries {
+		return nil
+	}
+	return err
+}
+
+// isTransient reports whether a write may succeed if attempted again
+func isTransient(err error) bool {
+	if errors.Is(err, bolt.ErrTimeout) {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
 }
 
 // get returns the object from the database
 func (c *Container[T]) get() error {
-	data, err := c.db.Get(c.bucketName, c.key)
+	data, rev, err := c.db.GetWithRevision(c.bucketName, c.key)
 	if err != nil {
 		return err
 	}
 
-	return c.decode(data)
+	if len(data) == 0 {
+		return &NotFoundError{Bucket: c.bucketName, Key: c.key}
+	}
+
+	if err = c.decode(data); err != nil {
+		return err
+	}
+
+	c.revision = rev
+	c.seen = max(c.seen, rev)
+	return nil
 }
 
 // set sets the object in the database
@@ -114,36 +393,202 @@ func (c *Container[T]) set() error {
 		return nil // No need to set if not modified
 	}
 
-	data, err := c.encode()
+	data, snapshot, err := c.stage()
 	if err != nil {
-		return err
+		return c.fail(err)
 	}
 
-	if err = c.db.Put(c.bucketName, c.key, data); err != nil {
-		return err
+	revs, err := c.db.PutMany([]store.Entry{c.entry(data)})
+	if err != nil {
+		var conflict *store.ConflictError
+		if errors.As(err, &conflict) && conflict.Actual == 0 {
+			c.gone() // the next write creates the key again
+		}
+		return c.fail(err)
+	}
+
+	c.commit(snapshot, revs[0])
+
+	return nil
+}
+
+// stage validates and encodes the object, it returns the value to store and its snapshot
+func (c *Container[T]) stage() ([]byte, []byte, error) {
+	if err := validation.Validate(&c.item); err != nil {
+		return nil, nil, err
 	}
 
+	snapshot, err := c.encode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := c.marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, snapshot, nil
+}
+
+// entry returns the write of the encoded object, it only succeeds if the key is still at the
+// revision the container knows and it restarts the lifetime set with WithTTL
+func (c *Container[T]) entry(data []byte) store.Entry {
+	return store.Entry{
+		Bucket:     c.bucketName,
+		Key:        c.key,
+		Value:      data,
+		IfRevision: true,
+		Revision:   c.revision,
+		TTL:        c.opts.ttl,
+	}
+}
+
+// commit marks the staged snapshot as saved at rev, the caller must hold the lock
+func (c *Container[T]) commit(snapshot []byte, rev uint64) {
+	c.notify(SourcePersist, c.snapshot, snapshot)
+
+	c.revision = rev
+	c.seen = max(c.seen, rev)
 	c.saved = true
 	c.modified = false
-	c.clone()
+	c.snapshot = snapshot
+	c.lastErr = nil
+	c.lastSavedAt = time.Now()
+	c.saves++
+	c.failures = 0
+	c.stalled = false
+	c.retryAt = time.Time{}
+	c.dirtySince = time.Time{}
+}
 
-	return nil
+// gone forgets the stored value after the key was deleted or expired, the object is written
+// again on the next save like a new one, the caller must hold the lock
+func (c *Container[T]) gone() {
+	c.saved = false
+	c.revision = 0
 }
 
-// Save saves the object to the database
+// fail records a failed write, the caller must hold the lock
+func (c *Container[T]) fail(err error) error {
+	c.lastErr = err
+	c.errors++
+	return err
+}
+
+// Save saves the object to the database, a *store.ConflictError is returned when the key was
+// written by someone else since the container last read or wrote it
 func (c *Container[T]) Save() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Check if the key exists in the database
-	if err := c.get(); err != nil {
+	if c.closed {
+		return ErrClosed
+	}
+
+	modified, err := c.changed()
+	if err != nil {
 		return err
 	}
 
-	// Update the database only if modified
+	// Update the database only if modified or never saved
+	c.modified = !c.saved || modified
+
 	return c.set()
 }
 
+// Update runs fn against the object under the container lock and persists the result according
+// to the write policy, right away with WriteThrough. If fn returns an error or the result fails
+// validation the object is restored and nothing is written
+func (c *Container[T]) Update(fn func(*T) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	prev, err := c.encode()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(&c.item); err == nil {
+		err = validation.Validate(&c.item)
+	}
+
+	if err != nil {
+		if restoreErr := c.restore(prev); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+		return err
+	}
+
+	modified, err := c.changed()
+	if err != nil {
+		return err
+	}
+
+	c.modified = c.modified || !c.saved || modified
+
+	if c.opts.policy == WriteThrough {
+		return c.set()
+	}
+
+	c.persist(time.Now())
+
+	return nil
+}
+
+// Flush persists the object if it changed since the last save
+func (c *Container[T]) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	return c.flush()
+}
+
+// flush persists pending changes, the caller must hold the lock
+func (c *Container[T]) flush() error {
+	modified, err := c.changed()
+	if err != nil {
+		return err
+	}
+
+	c.modified = c.modified || modified
+
+	return c.set()
+}
+
+// Close stops the isModified goroutine, persists pending changes and removes the container
+// from the store registry, a closed container can still be read but not updated
+func (c *Container[T]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.cancel()
+	c.closed = true
+	c.db.Untrack(c.uid)
+
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
+	return c.flush()
+}
+
 // IsModified returns true if the object has been modified
 func (c *Container[T]) IsModified() bool {
 	c.mu.RLock()
@@ -158,6 +603,50 @@ func (c *Container[T]) IsSaved() bool {
 	return c.saved
 }
 
+// LastError returns the error of the last failed write, nil once a write succeeds
+func (c *Container[T]) LastError() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// LastSavedAt returns the time of the last successful write
+func (c *Container[T]) LastSavedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastSavedAt
+}
+
+// Revision returns the revision of the stored value the container last read or wrote, 0 if none
+func (c *Container[T]) Revision() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.revision
+}
+
+// Stats returns the state of the container
+func (c *Container[T]) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return Stats{
+		Uid:         c.uid,
+		Type:        reflect.TypeFor[T]().String(),
+		Bucket:      c.bucketName,
+		Key:         c.key,
+		Timestamp:   c.timestamp,
+		Mode:        c.opts.mode,
+		Policy:      c.opts.policy,
+		Modified:    c.modified,
+		Saved:       c.saved,
+		Revision:    c.revision,
+		Saves:       c.saves,
+		Errors:      c.errors,
+		LastError:   c.lastErr,
+		LastSavedAt: c.lastSavedAt,
+	}
+}
+
 // GetObject returns the object
 func (c *Container[T]) GetObject() *T {
 	c.mu.RLock()
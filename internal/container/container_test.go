@@ -1,38 +1,492 @@
 package container
 
-//type testStruct struct {
-//	Name     string
-//	LastName string
-//	Age      int
-//}
-//
-//func TestNewContainer(t *testing.T) {
-//	db, err := store.NewStore(context.TODO(), "testNewContainer.db")
-//	assert.NoErrorf(t, err, "error creating store")
-//	assert.NotNil(t, db, "store is nil")
-//
-//	defer func() {
-//		err = db.Close()
-//		assert.NoErrorf(t, err, "error closing store")
-//
-//		err = os.Remove("testNewContainer.db")
-//		assert.NoErrorf(t, err, "error removing store")
-//	}()
-//
-//	container := NewContainer[testStruct](testStruct{
-//		Name:     "John",
-//		LastName: "Wick",
-//		Age:      42,
-//	}, "test", "testKey", db)
-//	assert.NotNil(t, container, "container is nil")
-//
-//	objectInstance := container.GetObject()
-//
-//	objectInstance.Age = 43
-//	objectInstance.Name = "John2"
-//	objectInstance.LastName = "Wick2"
-//
-//	<-time.After(5 * time.Second)
-//
-//	assert.Equalf(t, 43, container.GetObject().Age, "Age not equal")
-//}
+import (
+	"context"
+	"errors"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testStruct struct {
+	Name     string
+	LastName string
+	Age      int
+}
+
+type nestedStruct struct {
+	Tags    map[string]string
+	Scores  []int
+	Profile *testStruct
+}
+
+type validatedStruct struct {
+	Email string `validate:"required,email"`
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db, err := store.NewStore(ctx, filepath.Join(t.TempDir(), "container.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	assert.NotNil(t, db, "store is nil")
+
+	t.Cleanup(func() {
+		cancel()
+		assert.NoErrorf(t, db.Close(), "error closing store")
+	})
+
+	return db
+}
+
+func readTestStruct(t *testing.T, db *store.Store, bucketName, key string) testStruct {
+	data, err := db.Get(bucketName, key)
+	assert.NoErrorf(t, err, "error getting key")
+
+	var obj testStruct
+	_, err = unmarshalValue(data, &obj, location{bucketName, key})
+	assert.NoErrorf(t, err, "error decoding object")
+
+	return obj
+}
+
+func TestContainer_Update(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{
+		Name:     "John",
+		LastName: "Wick",
+		Age:      42,
+	}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NotNil(t, container, "container is nil")
+
+	err := container.Update(func(obj *testStruct) error {
+		obj.Age = 43
+		return nil
+	})
+	assert.NoErrorf(t, err, "error updating container")
+	assert.True(t, container.IsSaved(), "container not saved")
+	assert.False(t, container.IsModified(), "container still modified")
+	assert.Equal(t, 43, readTestStruct(t, db, "test", "testKey").Age)
+
+	errAbort := errors.New("abort")
+	err = container.Update(func(obj *testStruct) error {
+		obj.Age = 44
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	assert.Equal(t, 43, container.GetObject().Age, "failed update not rolled back")
+	assert.Equal(t, 43, readTestStruct(t, db, "test", "testKey").Age)
+}
+
+func TestContainer_Save(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db, WithMode(ModeExplicit))
+
+	assert.NoErrorf(t, container.Save(), "error saving container")
+	assert.Equal(t, "John", readTestStruct(t, db, "test", "testKey").Name)
+
+	container.GetObject().Name = "Jonathan"
+	assert.NoErrorf(t, container.Save(), "error saving container")
+	assert.Equal(t, "Jonathan", readTestStruct(t, db, "test", "testKey").Name)
+}
+
+func TestContainer_SaveConflict(t *testing.T) {
+	db := newTestStore(t)
+
+	first := NewContainer[testStruct](testStruct{Name: "John", Age: 42}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, first.Save(), "error saving container")
+
+	second, err := LoadContainer[testStruct](db, "test", "testKey", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, first.Revision(), second.Revision())
+
+	second.GetObject().Age = 43
+	assert.NoErrorf(t, second.Save(), "error saving container")
+
+	first.GetObject().Name = "Jonathan"
+	err = first.Save()
+	assert.ErrorIs(t, err, store.ErrConflict, "lost update")
+	assert.True(t, first.IsModified(), "conflicting container marked saved")
+	assert.Equal(t, 43, readTestStruct(t, db, "test", "testKey").Age)
+
+	assert.NoErrorf(t, first.Refresh(), "error refreshing container")
+	assert.Equal(t, testStruct{Name: "John", Age: 43}, *first.GetObject())
+	assert.Equal(t, second.Revision(), first.Revision())
+
+	first.GetObject().Name = "Jonathan"
+	assert.NoErrorf(t, first.Save(), "error saving container")
+	assert.Equal(t, testStruct{Name: "Jonathan", Age: 43}, readTestStruct(t, db, "test", "testKey"))
+
+	assert.NoError(t, second.Refresh())
+}
+
+func TestContainer_TTL(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "sessions", "john", db, WithMode(ModeExplicit), WithTTL(50*time.Millisecond))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	_, err := LoadContainer[testStruct](db, "sessions", "john", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+
+	time.Sleep(60 * time.Millisecond)
+
+	var notFound *NotFoundError
+	_, err = LoadContainer[testStruct](db, "sessions", "john", WithMode(ModeExplicit))
+	assert.ErrorAs(t, err, &notFound)
+
+	// the expired key is written again on the save after the conflict, like after a delete
+	var conflict *store.ConflictError
+	assert.ErrorAs(t, container.Update(func(obj *testStruct) error {
+		obj.Age = 42
+		return nil
+	}), &conflict)
+	assert.NoError(t, container.Save(), "expired key not written again")
+	assert.Equal(t, 42, readTestStruct(t, db, "sessions", "john").Age)
+
+	// Refresh finds the deleted key gone and keeps the object for the next save
+	assert.NoError(t, db.DeleteKey("sessions", "john"))
+	assert.ErrorAs(t, container.Refresh(), &notFound)
+	assert.False(t, container.IsSaved())
+	assert.Zero(t, container.Revision())
+
+	assert.NoError(t, container.Save())
+	assert.NotZero(t, container.Revision())
+}
+
+func TestLoadContainer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "load.db")
+
+	db, err := store.NewStore(context.Background(), path)
+	assert.NoErrorf(t, err, "error creating store")
+
+	container := NewContainer[testStruct](testStruct{Name: "John", Age: 42}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+	assert.NoErrorf(t, db.Close(), "error closing store")
+
+	// reopen the database to simulate a process restart
+	db, err = store.NewStore(context.Background(), path)
+	assert.NoErrorf(t, err, "error reopening store")
+	defer db.Close()
+
+	loaded, err := LoadContainer[testStruct](db, "test", "testKey", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, testStruct{Name: "John", Age: 42}, *loaded.GetObject())
+	assert.True(t, loaded.IsSaved(), "loaded container not saved")
+
+	_, err = LoadContainer[testStruct](db, "test", "missingKey", WithMode(ModeExplicit))
+	var notFound *NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "missingKey", notFound.Key)
+}
+
+func TestGetOrCreate(t *testing.T) {
+	db := newTestStore(t)
+
+	created, err := GetOrCreate[testStruct](testStruct{Name: "John"}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error creating container")
+	assert.Equal(t, "John", readTestStruct(t, db, "test", "testKey").Name)
+
+	assert.NoErrorf(t, created.Update(func(obj *testStruct) error {
+		obj.Name = "Jonathan"
+		return nil
+	}), "error updating container")
+
+	loaded, err := GetOrCreate[testStruct](testStruct{Name: "John"}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, "Jonathan", loaded.GetObject().Name)
+}
+
+func TestContainer_NestedChanges(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[nestedStruct](nestedStruct{
+		Tags:    map[string]string{"role": "admin"},
+		Scores:  []int{1, 2},
+		Profile: &testStruct{Name: "John"},
+	}, "test", "nestedKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	obj := container.GetObject()
+	obj.Tags["role"] = "user"
+	obj.Scores[0] = 10
+	obj.Profile.Name = "Jonathan"
+
+	container.checkModified(time.Now().Add(time.Minute))
+	assert.True(t, container.IsSaved(), "container not saved")
+	assert.False(t, container.IsModified(), "container still modified")
+
+	data, err := db.Get("test", "nestedKey")
+	assert.NoErrorf(t, err, "error getting key")
+
+	var stored nestedStruct
+	_, err = unmarshalValue(data, &stored, location{"test", "nestedKey"})
+	assert.NoErrorf(t, err, "error decoding object")
+	assert.Equal(t, "user", stored.Tags["role"])
+	assert.Equal(t, []int{10, 2}, stored.Scores)
+	assert.Equal(t, "Jonathan", stored.Profile.Name)
+
+	err = container.Update(func(obj *nestedStruct) error {
+		obj.Tags["role"] = "guest"
+		return errors.New("abort")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "user", container.GetObject().Tags["role"], "nested change not rolled back")
+}
+
+func TestContainer_Close(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db)
+	container.GetObject().Name = "Jonathan"
+
+	assert.NoErrorf(t, container.Close(), "error closing container")
+	assert.Equal(t, "Jonathan", readTestStruct(t, db, "test", "testKey").Name)
+	assert.ErrorIs(t, container.Update(func(obj *testStruct) error { return nil }), ErrClosed)
+	assert.NoErrorf(t, container.Close(), "closing twice should be a no-op")
+}
+
+func TestStore_CloseFlushesContainers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flush.db")
+
+	db, err := store.NewStore(context.Background(), path)
+	assert.NoErrorf(t, err, "error creating store")
+
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db)
+	container.GetObject().Age = 42
+
+	assert.NoErrorf(t, db.Close(), "error closing store")
+
+	db, err = store.NewStore(context.Background(), path)
+	assert.NoErrorf(t, err, "error reopening store")
+	defer db.Close()
+
+	assert.Equal(t, 42, readTestStruct(t, db, "test", "testKey").Age)
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestContainer_OnError(t *testing.T) {
+	db, err := store.NewStore(context.Background(), filepath.Join(t.TempDir(), "errors.db"))
+	assert.NoErrorf(t, err, "error creating store")
+
+	var reported []error
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "testKey", db,
+		WithMode(ModeExplicit),
+		WithOnError(func(err error) { reported = append(reported, err) }),
+	)
+	assert.NoErrorf(t, container.Save(), "error saving container")
+	assert.False(t, container.LastSavedAt().IsZero(), "last saved at not set")
+
+	// close the database underneath the container so every write fails
+	assert.NoError(t, db.DB.Close())
+
+	container.GetObject().Age = 42
+	tick := time.Now().Add(time.Minute)
+	container.checkModified(tick)
+
+	assert.Len(t, reported, 1)
+	assert.Error(t, container.LastError())
+	assert.True(t, container.IsModified(), "failed write cleared modified")
+
+	// the error isn't transient, the write isn't attempted again
+	container.checkModified(tick.Add(time.Hour))
+	assert.Len(t, reported, 1)
+}
+
+func TestContainer_RetryTransientErrors(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[testStruct](testStruct{}, "test", "testKey", db, WithMode(ModeExplicit), WithRetry(3, time.Second))
+
+	now := time.Now()
+	assert.NoError(t, container.failed(now, temporaryError{}))
+	assert.Equal(t, now.Add(time.Second), container.retryAt)
+	assert.NoError(t, container.failed(now, temporaryError{}))
+	assert.Equal(t, now.Add(2*time.Second), container.retryAt)
+	assert.NoError(t, container.failed(now, temporaryError{}))
+	assert.Error(t, container.failed(now, temporaryError{}), "retries exhausted")
+}
+
+func TestContainer_RetryBackgroundWrites(t *testing.T) {
+	db := newTestStore(t)
+
+	// the store rejects the first writes with a transient error
+	failures := 3
+	db.RegisterValidator("test", func(key string, value []byte) error {
+		if failures > 0 {
+			failures--
+			return temporaryError{}
+		}
+		return nil
+	})
+
+	var reported []error
+	container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "retryKey", db,
+		WithMode(ModeExplicit),
+		WithRetry(3, time.Second),
+		WithOnError(func(err error) { reported = append(reported, err) }),
+	)
+
+	container.GetObject().Age = 42
+	tick := time.Now()
+	for range 3 {
+		container.checkModified(tick)
+		assert.True(t, container.IsModified(), "failed write cleared modified")
+		tick = container.retryAt
+	}
+
+	container.checkModified(tick)
+	assert.Empty(t, reported, "transient error reported before the retries ran out")
+	assert.False(t, container.IsModified(), "third retry not written")
+	assert.Equal(t, 42, readTestStruct(t, db, "test", "retryKey").Age)
+
+	// a conflict is reported once and not retried until the object changes
+	assert.NoError(t, db.Put("test", "retryKey", []byte("elsewhere")))
+
+	container.GetObject().Age = 43
+	container.checkModified(tick)
+	container.checkModified(tick.Add(time.Hour))
+
+	var conflict *store.ConflictError
+	assert.Len(t, reported, 1)
+	assert.ErrorAs(t, reported[0], &conflict)
+
+	container.GetObject().Age = 44
+	container.checkModified(tick.Add(2 * time.Hour))
+	assert.Len(t, reported, 2, "new change not written")
+	assert.ErrorAs(t, container.Close(), &conflict)
+}
+
+func TestContainer_Validation(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[validatedStruct](validatedStruct{Email: "john@example.com"}, "test", "testKey", db, WithMode(ModeExplicit))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	err := container.Update(func(obj *validatedStruct) error {
+		obj.Email = "john"
+		return nil
+	})
+
+	var validationErr *validation.Error
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "Email", validationErr.Fields[0].Field)
+	assert.Equal(t, "john@example.com", container.GetObject().Email, "invalid update not rolled back")
+
+	data, err := db.Get("test", "testKey")
+	assert.NoErrorf(t, err, "error getting key")
+
+	var stored validatedStruct
+	_, err = unmarshalValue(data, &stored, location{"test", "testKey"})
+	assert.NoErrorf(t, err, "error decoding object")
+	assert.Equal(t, "john@example.com", stored.Email, "invalid object was written")
+}
+
+func TestContainer_Policies(t *testing.T) {
+	db := newTestStore(t)
+
+	setAge := func(age int) func(obj *testStruct) error {
+		return func(obj *testStruct) error {
+			obj.Age = age
+			return nil
+		}
+	}
+
+	t.Run("write-behind", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "behindKey", db,
+			WithMode(ModeExplicit), WithWriteBehind(50*time.Millisecond, time.Second))
+		assert.Equal(t, WriteBehind, container.Stats().Policy)
+
+		assert.NoError(t, container.Update(setAge(42)))
+		assert.True(t, container.IsModified(), "write behind wrote right away")
+
+		data, err := db.Get("test", "behindKey")
+		assert.NoError(t, err)
+		assert.Empty(t, data)
+
+		assert.Eventually(t, func() bool { return !container.IsModified() }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 42, readTestStruct(t, db, "test", "behindKey").Age)
+		assert.Equal(t, int64(1), container.Stats().Saves)
+	})
+
+	t.Run("write-behind polling", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "pollingKey", db,
+			WithPollInterval(10*time.Millisecond), WithWriteBehind(50*time.Millisecond, 5*time.Second))
+
+		// every tick sees the unsaved change, only the first one schedules the write
+		container.mu.Lock()
+		container.item.Age = 42
+		container.mu.Unlock()
+
+		assert.Eventually(t, func() bool { return container.Stats().Saves == 1 }, time.Second, 10*time.Millisecond,
+			"write deferred to the max delay")
+		assert.Equal(t, 42, readTestStruct(t, db, "test", "pollingKey").Age)
+	})
+
+	t.Run("manual", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "manualKey", db,
+			WithMode(ModeExplicit), WithPolicy(Manual))
+
+		assert.NoError(t, container.Update(setAge(42)))
+		container.checkModified(time.Now())
+		assert.True(t, container.IsModified(), "manual policy wrote without Save")
+
+		assert.NoError(t, container.Save())
+		assert.Equal(t, 42, readTestStruct(t, db, "test", "manualKey").Age)
+
+		stats := container.Stats()
+		assert.Equal(t, Manual, stats.Policy)
+		assert.Equal(t, "manual", stats.Policy.String())
+		assert.True(t, stats.Saved)
+		assert.False(t, stats.Modified)
+	})
+}
+
+func TestContainer_Watch(t *testing.T) {
+	db := newTestStore(t)
+
+	container := NewContainer[nestedStruct](nestedStruct{Tags: map[string]string{"role": "admin"}}, "test", "watchKey", db, WithMode(ModeExplicit))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := container.Watch(ctx)
+
+	assert.NoError(t, container.Update(func(obj *nestedStruct) error {
+		obj.Tags["role"] = "user"
+		return nil
+	}))
+
+	local := <-changes
+	assert.Equal(t, SourceLocal, local.Source)
+	assert.Equal(t, "admin", local.Old.Tags["role"])
+	assert.Equal(t, "user", local.New.Tags["role"])
+
+	persisted := <-changes
+	assert.Equal(t, SourcePersist, persisted.Source)
+	assert.Equal(t, "admin", persisted.Old.Tags["role"])
+	assert.Equal(t, "user", persisted.New.Tags["role"])
+
+	// changes made through GetObject are sent when they are found
+	container.GetObject().Scores = []int{1}
+	container.checkModified(time.Now())
+
+	assert.Equal(t, SourceLocal, (<-changes).Source)
+	assert.Equal(t, []int{1}, (<-changes).New.Scores)
+
+	local.New.Tags["role"] = "guest"
+	assert.Equal(t, "user", container.GetObject().Tags["role"], "change shares a map with the object")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, open := <-changes
+		return !open
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,254 @@
+package container
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// encryptedPrefix marks an encrypted value, values without it were written before the
+	// field was tagged and are loaded as they are, then encrypted on the next write
+	encryptedPrefix = "enc:"
+
+	keyIDSize = 4
+)
+
+// ErrNoEncryptionKey is returned when a field tagged `pc:"encrypt"` is written before
+// SetEncryptionKeys is called, or read without the key it was encrypted with
+var ErrNoEncryptionKey = errors.New("no encryption key")
+
+var (
+	// encryptedFields caches the index of the fields tagged `pc:"encrypt"` of each type
+	encryptedFields sync.Map
+
+	keyringMu sync.RWMutex
+	keyring   []encryptionKey
+)
+
+type (
+	// encryptionKey is an AES-GCM key, id is the start of the sha256 of the key and is stored
+	// with every value so it is decrypted with the key it was encrypted with
+	encryptionKey struct {
+		id   []byte
+		aead cipher.AEAD
+	}
+
+	// location is where a value is stored, its encrypted fields are sealed with the bucket, the
+	// key and the field name as additional data so they can't be copied to another field or record
+	location struct {
+		bucket string
+		key    string
+	}
+)
+
+// SetEncryptionKeys sets the AES keys of the fields tagged `pc:"encrypt"`, each one 16, 24 or 32
+// bytes long. The first key encrypts the values written from now on and every key decrypts the
+// values written with it, a key is rotated by putting the new one first. The keys are never
+// stored, they must come from the application, without keys the tagged fields can't be written
+func SetEncryptionKeys(keys ...[]byte) error {
+	ring := make([]encryptionKey, 0, len(keys))
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("encryption key %d: %w", i, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("encryption key %d: %w", i, err)
+		}
+
+		sum := sha256.Sum256(key)
+		ring = append(ring, encryptionKey{id: sum[:keyIDSize], aead: aead})
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+
+	keyring = ring
+	return nil
+}
+
+// aad returns the additional data of a field of the value stored at the location
+func (at location) aad(field string) []byte {
+	data := binary.AppendUvarint(nil, uint64(len(field)))
+	data = append(data, field...)
+	data = binary.AppendUvarint(data, uint64(len(at.bucket)))
+	data = append(data, at.bucket...)
+	return append(data, at.key...)
+}
+
+// seal encrypts the data with the first key and a random nonce, the result holds the key id,
+// the nonce and the sealed data. The key id and aad are authenticated with it
+func seal(data []byte, aad []byte) ([]byte, error) {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+
+	if len(keyring) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+	key := keyring[0]
+
+	out := make([]byte, keyIDSize+key.aead.NonceSize(), keyIDSize+key.aead.NonceSize()+len(data)+key.aead.Overhead())
+	copy(out, key.id)
+
+	nonce := out[keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return key.aead.Seal(out, nonce, data, slices.Concat(key.id, aad)), nil
+}
+
+// open decrypts data sealed with one of the keys and the same aad
+func open(data []byte, aad []byte) ([]byte, error) {
+	if len(data) < keyIDSize {
+		return nil, errors.New("encrypted value too short")
+	}
+
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+
+	id := data[:keyIDSize]
+	for _, key := range keyring {
+		if !bytes.Equal(key.id, id) {
+			continue
+		}
+
+		if len(data) < keyIDSize+key.aead.NonceSize() {
+			return nil, errors.New("encrypted value too short")
+		}
+
+		nonce, sealed := data[keyIDSize:keyIDSize+key.aead.NonceSize()], data[keyIDSize+key.aead.NonceSize():]
+		return key.aead.Open(nil, nonce, sealed, slices.Concat(id, aad))
+	}
+
+	return nil, fmt.Errorf("%w with id %x", ErrNoEncryptionKey, id)
+}
+
+// encryptedFieldIndexes returns the index of the exported string and []byte fields tagged
+// `pc:"encrypt"`, nested structs are not walked
+func encryptedFieldIndexes(t reflect.Type) ([][]int, error) {
+	if cached, ok := encryptedFields.Load(t); ok {
+		return cached.([][]int), nil
+	}
+
+	var indexes [][]int
+	if t.Kind() == reflect.Struct {
+		for _, field := range reflect.VisibleFields(t) {
+			if !field.IsExported() || !hasTag(field, tagEncrypt) {
+				continue
+			}
+
+			if field.Type.Kind() != reflect.String && field.Type != reflect.TypeFor[[]byte]() {
+				return nil, fmt.Errorf("field %s of %s tagged encrypt must be a string or []byte", field.Name, t)
+			}
+			indexes = append(indexes, field.Index)
+		}
+	}
+
+	encryptedFields.Store(t, indexes)
+	return indexes, nil
+}
+
+// encryptFields returns a copy of the value v points to with the tagged fields encrypted with
+// AES-GCM for the location, v itself is returned when the type has none. Renaming a tagged field
+// or moving a value to another key makes the fields it holds unreadable
+func encryptFields(v any, at location) (any, error) {
+	value := reflect.ValueOf(v).Elem()
+
+	indexes, err := encryptedFieldIndexes(value.Type())
+	if err != nil || len(indexes) == 0 {
+		return v, err
+	}
+
+	encrypted := reflect.New(value.Type())
+	encrypted.Elem().Set(value)
+
+	for _, index := range indexes {
+		field := encrypted.Elem().FieldByIndex(index)
+		aad := at.aad(value.Type().FieldByIndex(index).Name)
+
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() == "" {
+				continue
+			}
+
+			data, err := seal([]byte(field.String()), aad)
+			if err != nil {
+				return nil, err
+			}
+			field.SetString(encryptedPrefix + base64.RawStdEncoding.EncodeToString(data))
+		default:
+			if field.Len() == 0 {
+				continue
+			}
+
+			data, err := seal(field.Bytes(), aad)
+			if err != nil {
+				return nil, err
+			}
+			field.SetBytes(append([]byte(encryptedPrefix), data...))
+		}
+	}
+
+	return encrypted.Interface(), nil
+}
+
+// decryptFields decrypts in place the tagged fields of the value v points to, stored at the location
+func decryptFields(v any, at location) error {
+	value := reflect.ValueOf(v).Elem()
+
+	indexes, err := encryptedFieldIndexes(value.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		field := value.FieldByIndex(index)
+		name := value.Type().FieldByIndex(index).Name
+
+		switch field.Kind() {
+		case reflect.String:
+			data, ok := strings.CutPrefix(field.String(), encryptedPrefix)
+			if !ok {
+				continue
+			}
+
+			sealed, err := base64.RawStdEncoding.DecodeString(data)
+			if err != nil {
+				return fmt.Errorf("decrypting field %s: %w", name, err)
+			}
+
+			plain, err := open(sealed, at.aad(name))
+			if err != nil {
+				return fmt.Errorf("decrypting field %s: %w", name, err)
+			}
+			field.SetString(string(plain))
+		default:
+			data, ok := bytes.CutPrefix(field.Bytes(), []byte(encryptedPrefix))
+			if !ok {
+				continue
+			}
+
+			plain, err := open(data, at.aad(name))
+			if err != nil {
+				return fmt.Errorf("decrypting field %s: %w", name, err)
+			}
+			field.SetBytes(plain)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type customer struct {
+	Name  string
+	Email string `pc:"encrypt"`
+	Token []byte `pc:"encrypt"`
+}
+
+// setTestKeys sets the encryption keys until the end of the test
+func setTestKeys(t *testing.T, keys ...[]byte) {
+	assert.NoError(t, SetEncryptionKeys(keys...))
+	t.Cleanup(func() {
+		assert.NoError(t, SetEncryptionKeys())
+	})
+}
+
+func TestContainer_EncryptedFields(t *testing.T) {
+	db := newTestStore(t)
+
+	key, rotated := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	setTestKeys(t)
+
+	obj := customer{Name: "John", Email: "john@wick.com", Token: []byte("secret")}
+	container := NewContainer[customer](obj, "customers", "john", db, WithMode(ModeExplicit), WithCodec(JSON))
+	assert.ErrorIs(t, container.Save(), ErrNoEncryptionKey, "encrypted without a key")
+
+	assert.Error(t, SetEncryptionKeys([]byte("short")))
+	assert.NoError(t, SetEncryptionKeys(key))
+	assert.NoErrorf(t, container.Save(), "error saving container")
+
+	data, err := db.Get("customers", "john")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"John"`, "plaintext field not queryable")
+	assert.NotContains(t, string(data), "john@wick.com", "email stored in plaintext")
+
+	codec, _, payload, err := unwrap(data)
+	assert.NoError(t, err)
+
+	var stored customer
+	assert.NoError(t, codec.Unmarshal(payload, &stored))
+	assert.True(t, strings.HasPrefix(stored.Email, encryptedPrefix))
+	assert.True(t, strings.HasPrefix(string(stored.Token), encryptedPrefix))
+
+	// encryption is randomized, the unchanged object must not be written again
+	assert.NoError(t, container.Save())
+	assert.Equal(t, int64(1), container.Stats().Saves)
+	assert.Equal(t, obj, *container.GetObject(), "in-memory object encrypted")
+
+	loaded, err := LoadContainer[customer](db, "customers", "john", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, obj, *loaded.GetObject())
+	assert.NoError(t, loaded.Close())
+
+	// the old key still decrypts the values written before the rotation
+	assert.NoError(t, SetEncryptionKeys(rotated, key))
+	loaded, err = LoadContainer[customer](db, "customers", "john", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container after rotation")
+	assert.Equal(t, obj, *loaded.GetObject())
+	assert.NoError(t, loaded.Close())
+
+	assert.NoError(t, SetEncryptionKeys(rotated))
+	_, err = LoadContainer[customer](db, "customers", "john", WithMode(ModeExplicit))
+	assert.ErrorIs(t, err, ErrNoEncryptionKey)
+	assert.NoError(t, container.Close())
+
+	// values written before the field was tagged are loaded as they are
+	at := location{"customers", "legacy"}
+	legacy, err := marshalValue(CBOR, &map[string]string{"Name": "John", "Email": "john@wick.com"}, at)
+	assert.NoError(t, err)
+
+	var plain customer
+	_, err = unmarshalValue(legacy, &plain, at)
+	assert.NoError(t, err)
+	assert.Equal(t, "john@wick.com", plain.Email)
+}
+
+type contact struct {
+	Email string `pc:"encrypt"`
+	Phone string `pc:"encrypt"`
+}
+
+func TestEncryptedFieldsBound(t *testing.T) {
+	setTestKeys(t, bytes.Repeat([]byte{1}, 32))
+
+	at := location{"contacts", "john"}
+	encrypted, err := encryptFields(&contact{Email: "john@wick.com", Phone: "555-0100"}, at)
+	assert.NoError(t, err)
+	sealed := *encrypted.(*contact)
+
+	// a sealed field only opens in its own field of its own record
+	swapped := contact{Email: sealed.Phone, Phone: sealed.Email}
+	assert.Error(t, decryptFields(&swapped, at), "field copied to another field")
+
+	for _, other := range []location{{"contacts", "jane"}, {"archive", "john"}} {
+		moved := sealed
+		assert.Error(t, decryptFields(&moved, other), "record copied to %v", other)
+	}
+
+	opened := sealed
+	assert.NoError(t, decryptFields(&opened, at))
+	assert.Equal(t, contact{Email: "john@wick.com", Phone: "555-0100"}, opened)
+}
@@ -0,0 +1,131 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const (
+	envelopeV1 = 1 // codec id only
+	envelopeV2 = 2 // codec id and schema version
+
+	envelopeVersion = envelopeV2
+)
+
+// magic starts every binary envelope, a single cbor item can't start with 0x00 and be followed
+// by more data, so values written before codecs existed are never mistaken for an envelope
+var magic = []byte{0x00, 'p', 'c'}
+
+type (
+	// jsonEnvelope wraps json values so they stay readable by tools that only speak json
+	jsonEnvelope struct {
+		Version int             `json:"pc"`
+		Codec   string          `json:"codec"`
+		Schema  uint64          `json:"schema,omitempty"`
+		Data    json.RawMessage `json:"data"`
+	}
+)
+
+// marshalValue encodes v with the codec and wraps it with the codec id and the schema version,
+// the fields tagged `pc:"encrypt"` are encrypted in the stored value only, for the location the
+// value is written to. A type with a schema can't be written with gob as its values couldn't be migrated
+func marshalValue(codec Codec, v any, at location) ([]byte, error) {
+	encrypted, err := encryptFields(v, at)
+	if err != nil {
+		return nil, err
+	}
+
+	version := uint64(1)
+	if s := lookupSchema(reflect.TypeOf(v).Elem()); s != nil {
+		if codec.ID() == CodecGob {
+			return nil, fmt.Errorf("schema %s: %w", s.name, ErrGobSchema)
+		}
+		version = uint64(len(s.migrations)) + 1
+	}
+
+	payload, err := codec.Marshal(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec.ID() == CodecJSON {
+		return json.Marshal(jsonEnvelope{Version: envelopeVersion, Codec: codec.Name(), Schema: version, Data: payload})
+	}
+
+	data := make([]byte, 0, len(magic)+2+binary.MaxVarintLen64+len(payload))
+	data = append(data, magic...)
+	data = append(data, envelopeVersion, codec.ID())
+	data = binary.AppendUvarint(data, version)
+	return append(data, payload...), nil
+}
+
+// unmarshalValue decodes a value stored at the location into v with the codec it was written
+// with, running the schema migrations of the type if the value is older, values without an
+// envelope are decoded as cbor. Encrypted fields are decrypted, migrations still see them
+// encrypted. It reports whether the value was migrated
+func unmarshalValue(data []byte, v any, at location) (bool, error) {
+	codec, version, payload, err := unwrap(data)
+	if err != nil {
+		return false, err
+	}
+
+	migrated := false
+
+	s := lookupSchema(reflect.TypeOf(v).Elem())
+	if s == nil || version == uint64(len(s.migrations))+1 {
+		err = codec.Unmarshal(payload, v)
+	} else {
+		migrated = true
+		err = migrate(s, codec, payload, version, v)
+	}
+
+	if err != nil {
+		return migrated, err
+	}
+	return migrated, decryptFields(v, at)
+}
+
+// unwrap returns the codec, the schema version and the payload of a stored value
+func unwrap(data []byte) (Codec, uint64, []byte, error) {
+	if bytes.HasPrefix(data, magic) {
+		if len(data) < len(magic)+2 {
+			return nil, 0, nil, errors.New("envelope too short")
+		}
+
+		codec, err := lookupCodec(data[len(magic)+1])
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		payload := data[len(magic)+2:]
+
+		switch data[len(magic)] {
+		case envelopeV1:
+			return codec, 1, payload, nil
+		case envelopeV2:
+			version, n := binary.Uvarint(payload)
+			if n <= 0 {
+				return nil, 0, nil, errors.New("invalid schema version")
+			}
+			return codec, version, payload[n:], nil
+		default:
+			return nil, 0, nil, fmt.Errorf("unknown envelope version %d", data[len(magic)])
+		}
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		var env jsonEnvelope
+		if err := json.Unmarshal(data, &env); err == nil && env.Version > 0 && env.Codec == JSON.Name() {
+			if env.Schema == 0 {
+				env.Schema = 1
+			}
+			return JSON, env.Schema, env.Data, nil
+		}
+	}
+
+	return CBOR, 1, data, nil
+}
@@ -0,0 +1,407 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Reducer folds an event into the state and returns the new state, it must not
+	// have side effects since it runs again every time the log is replayed
+	Reducer[T, E any] func(state T, event E) T
+
+	// Record is an event of the log with its sequence number and the time it was appended
+	Record[E any] struct {
+		Seq   uint64
+		Time  time.Time
+		Event E
+	}
+
+	// EventSourced is a container that keeps the history of its object. Instead of overwriting
+	// the object it appends events to a log bucket of the key and rebuilds the object by folding
+	// them through a reducer. The state is snapshot to the log every few events so loading it
+	// only replays the tail of the log. E must be a concrete type the codec can decode, a
+	// struct with one pointer field per kind of event works with every codec
+	EventSourced[T, E any] struct {
+		uid         string
+		state       T
+		seq         uint64
+		snapshotSeq uint64
+		bucketName  string
+		key         string
+		logBucket   string
+		reducer     Reducer[T, E]
+		db          *store.Store
+		closed      bool
+		lastErr     error
+		mu          sync.RWMutex
+		opts        options
+	}
+
+	// storedRecord is an event as kept in the log, the event has its own envelope
+	// so it gets the codec and the schema migrations of E
+	storedRecord struct {
+		Time  int64
+		Event []byte
+	}
+
+	// storedSnapshot is the state folded up to Seq, the state has its own envelope
+	// so it gets the codec and the schema migrations of T
+	storedSnapshot struct {
+		Seq   uint64
+		State []byte
+	}
+)
+
+// NewEventSourced loads the event-sourced object stored in bucketName/key, its last snapshot
+// or initial when it has none is brought up to date with the events appended after it. The key
+// names the log so it can't hold the path separator
+func NewEventSourced[T, E any](db *store.Store, bucketName string, key string, initial T, reducer Reducer[T, E], opts ...Option) (*EventSourced[T, E], error) {
+	log, err := logBucket(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &EventSourced[T, E]{
+		uid:        uuid.NewString(),
+		state:      initial,
+		bucketName: bucketName,
+		key:        key,
+		logBucket:  log,
+		reducer:    reducer,
+		db:         db,
+		mu:         sync.RWMutex{},
+		opts:       defaultOptions(),
+	}
+
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	db.Track(c.uid, c)
+
+	return c, nil
+}
+
+// ReadEvents returns the events of bucketName/key appended after the sequence after, in order
+func ReadEvents[E any](db *store.Store, bucketName string, key string, after uint64) ([]Record[E], error) {
+	log, err := logBucket(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record[E]
+	err = readLog[E](db, log, after, func(record Record[E]) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// Replay folds the whole log of bucketName/key into a new projection, snapshots are
+// ignored since they hold the state of another reducer. It returns the projection and
+// the sequence of the last event folded
+func Replay[P, E any](db *store.Store, bucketName string, key string, initial P, reducer Reducer[P, E]) (P, uint64, error) {
+	log, err := logBucket(bucketName, key)
+	if err != nil {
+		return initial, 0, err
+	}
+
+	var seq uint64
+	err = readLog[E](db, log, 0, func(record Record[E]) error {
+		initial = reducer(initial, record.Event)
+		seq = record.Seq
+		return nil
+	})
+	return initial, seq, err
+}
+
+// logBucket returns the name of the bucket holding the events of a key, the key is the last
+// name of the log path so it must be a single name
+func logBucket(bucketName string, key string) (string, error) {
+	if key == "" || strings.Contains(key, store.PathSeparator) {
+		return "", fmt.Errorf("%w: key %q can't name an event log", store.ErrInvalidPath, key)
+	}
+	return fmt.Sprintf("%s:events:%s", bucketName, key), nil
+}
+
+// readLog decodes the events of a log bucket appended after the sequence after
+func readLog[E any](db *store.Store, bucketName string, after uint64, fn func(Record[E]) error) error {
+	return db.ReadLog(bucketName, after, func(seq uint64, value []byte) error {
+		var stored storedRecord
+		if err := cbor.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("decoding event %d of %s: %w", seq, bucketName, err)
+		}
+
+		record := Record[E]{Seq: seq, Time: time.Unix(0, stored.Time)}
+		if _, err := unmarshalValue(stored.Event, &record.Event, location{bucket: bucketName}); err != nil {
+			return fmt.Errorf("decoding event %d of %s: %w", seq, bucketName, err)
+		}
+		return fn(record)
+	})
+}
+
+// load restores the last snapshot and folds the events appended after it
+func (c *EventSourced[T, E]) load() error {
+	data, err := c.db.GetLogSnapshot(c.logBucket)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		var snapshot storedSnapshot
+		if err = cbor.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("decoding snapshot of %s/%s: %w", c.bucketName, c.key, err)
+		}
+
+		var state T
+		if _, err = unmarshalValue(snapshot.State, &state, c.snapshotLocation()); err != nil {
+			return fmt.Errorf("decoding snapshot of %s/%s: %w", c.bucketName, c.key, err)
+		}
+
+		c.state = state
+		c.seq = snapshot.Seq
+		c.snapshotSeq = snapshot.Seq
+	}
+
+	return c.catchUp()
+}
+
+// catchUp folds the events appended by others since the last known one, the caller must hold the lock
+func (c *EventSourced[T, E]) catchUp() error {
+	return readLog[E](c.db, c.logBucket, c.seq, func(record Record[E]) error {
+		c.state = c.reducer(c.state, record.Event)
+		c.seq = record.Seq
+		return nil
+	})
+}
+
+// fold returns the state after the events without touching the current one, the
+// result must pass validation
+func (c *EventSourced[T, E]) fold(events []E) (T, error) {
+	// fold a copy so a rejected state leaves nothing behind in maps, slices or pointers
+	next, err := c.copyState()
+	if err != nil {
+		return next, err
+	}
+
+	for _, event := range events {
+		next = c.reducer(next, event)
+	}
+
+	return next, validation.Validate(&next)
+}
+
+// Apply appends the events to the log and folds them into the state, all of them or none.
+// The events are checked against the latest state, when someone else appended events in the
+// meantime they are folded first. Nothing is written if the resulting state fails validation
+func (c *EventSourced[T, E]) Apply(events ...E) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	values := make([][]byte, len(events))
+	for i := range events {
+		// the events are bound to the log, their sequence is only known once they are appended
+		event, err := marshalValue(c.opts.codec, &events[i], location{bucket: c.logBucket})
+		if err != nil {
+			return err
+		}
+
+		if values[i], err = encMode.Marshal(storedRecord{Time: now, Event: event}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		next, err := c.fold(events)
+		if err != nil {
+			return err
+		}
+
+		seq, err := c.db.Append(c.logBucket, c.seq, values...)
+		if errors.Is(err, store.ErrConflict) {
+			known := c.seq
+			if err := c.catchUp(); err != nil {
+				return err
+			}
+
+			if c.seq == known {
+				return c.fail(err) // the log went back, like when its bucket was deleted
+			}
+			continue
+		}
+
+		if err != nil {
+			return c.fail(err)
+		}
+
+		c.state = next
+		c.seq = seq
+		c.lastErr = nil
+		break
+	}
+
+	if c.opts.snapshotEvery > 0 && c.seq-c.snapshotSeq >= uint64(c.opts.snapshotEvery) {
+		// the events are stored, a failed snapshot is reported and attempted again on the next event
+		if err := c.snapshot(); err != nil {
+			c.lastErr = err
+			if c.opts.onError != nil {
+				c.opts.onError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fail records a failed write, the caller must hold the lock
+func (c *EventSourced[T, E]) fail(err error) error {
+	c.lastErr = err
+	return err
+}
+
+// snapshot writes the state folded so far, the caller must hold the lock
+func (c *EventSourced[T, E]) snapshot() error {
+	state, err := marshalValue(c.opts.codec, &c.state, c.snapshotLocation())
+	if err != nil {
+		return err
+	}
+
+	data, err := encMode.Marshal(storedSnapshot{Seq: c.seq, State: state})
+	if err != nil {
+		return err
+	}
+
+	if err = c.db.PutLogSnapshot(c.logBucket, data); err != nil {
+		return err
+	}
+
+	c.snapshotSeq = c.seq
+	return nil
+}
+
+// snapshotLocation is where the snapshot is stored, the events of the log are bound to the log only
+func (c *EventSourced[T, E]) snapshotLocation() location {
+	return location{bucket: c.logBucket, key: "snapshot"}
+}
+
+// Refresh folds the events appended by others since the last one the container knows of
+func (c *EventSourced[T, E]) Refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	return c.catchUp()
+}
+
+// Events returns the events appended after the sequence after, in order
+func (c *EventSourced[T, E]) Events(after uint64) ([]Record[E], error) {
+	return ReadEvents[E](c.db, c.bucketName, c.key, after)
+}
+
+// Snapshot writes the current state so later loads start from it
+func (c *EventSourced[T, E]) Snapshot() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.snapshot()
+}
+
+// Flush writes a snapshot if events were appended since the last one
+func (c *EventSourced[T, E]) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flush()
+}
+
+func (c *EventSourced[T, E]) flush() error {
+	if c.seq == c.snapshotSeq {
+		return nil
+	}
+	return c.snapshot()
+}
+
+// Close writes a pending snapshot and stops the container
+func (c *EventSourced[T, E]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	c.db.Untrack(c.uid)
+
+	return c.flush()
+}
+
+// copyState returns a deep copy of the state, the caller must hold the lock
+func (c *EventSourced[T, E]) copyState() (T, error) {
+	var state T
+
+	data, err := encMode.Marshal(&c.state)
+	if err != nil {
+		return state, err
+	}
+
+	err = cbor.Unmarshal(data, &state)
+	return state, err
+}
+
+// State returns a copy of the state folded from every event known to the container, changing
+// it doesn't change the state, that only happens through Apply. The zero value is returned
+// if the state can't be encoded, Apply fails with the encoding error then
+func (c *EventSourced[T, E]) State() T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state, err := c.copyState()
+	if err != nil {
+		var zero T
+		return zero
+	}
+	return state
+}
+
+// Seq returns the sequence of the last event folded into the state
+func (c *EventSourced[T, E]) Seq() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.seq
+}
+
+// LastError returns the error of the last failed write, nil once a write succeeds
+func (c *EventSourced[T, E]) LastError() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// GetUid returns the uid
+func (c *EventSourced[T, E]) GetUid() string {
+	return c.uid
+}
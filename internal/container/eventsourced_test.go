@@ -0,0 +1,128 @@
+package container
+
+import (
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type wallet struct {
+	Balance int `validate:"gte=0"`
+}
+
+type walletEvent struct {
+	Deposited *int
+	Withdrawn *int
+}
+
+func deposit(amount int) walletEvent  { return walletEvent{Deposited: &amount} }
+func withdraw(amount int) walletEvent { return walletEvent{Withdrawn: &amount} }
+
+func reduceWallet(state wallet, event walletEvent) wallet {
+	switch {
+	case event.Deposited != nil:
+		state.Balance += *event.Deposited
+	case event.Withdrawn != nil:
+		state.Balance -= *event.Withdrawn
+	}
+	return state
+}
+
+func TestEventSourced(t *testing.T) {
+	db := newTestStore(t)
+
+	acc, err := NewEventSourced[wallet, walletEvent](db, "wallets", "john", wallet{}, reduceWallet, WithSnapshotEvery(2))
+	assert.NoErrorf(t, err, "error creating event-sourced container")
+
+	assert.NoError(t, acc.Apply(deposit(100), withdraw(30)))
+	assert.NoError(t, acc.Apply(deposit(5)))
+	assert.Equal(t, 75, acc.State().Balance)
+	assert.Equal(t, uint64(3), acc.Seq())
+
+	assert.Error(t, acc.Apply(withdraw(100)), "invalid state accepted")
+	assert.Equal(t, 75, acc.State().Balance)
+	assert.Equal(t, uint64(3), acc.Seq(), "rejected event appended")
+
+	records, err := acc.Events(0)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, 100, *records[0].Event.Deposited)
+	assert.Equal(t, uint64(3), records[2].Seq)
+
+	// a second writer folds the events it missed before appending its own
+	other, err := NewEventSourced[wallet, walletEvent](db, "wallets", "john", wallet{}, reduceWallet)
+	assert.NoErrorf(t, err, "error loading event-sourced container")
+	assert.Equal(t, 75, other.State().Balance)
+	assert.Equal(t, uint64(2), other.snapshotSeq, "snapshot not loaded")
+
+	// the snapshot is kept with the log, the data bucket only holds the values of containers
+	value, err := db.Get("wallets", "john")
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+
+	assert.NoError(t, acc.Apply(withdraw(75)))
+	assert.Error(t, other.Apply(withdraw(1)), "withdrawal checked against a stale balance")
+	assert.Zero(t, other.State().Balance)
+	assert.NoError(t, other.Apply(deposit(10)))
+	assert.NoError(t, acc.Refresh())
+	assert.Equal(t, 10, acc.State().Balance)
+
+	// the log is replayable into a new projection
+	deposits, seq, err := Replay[int, walletEvent](db, "wallets", "john", 0, func(count int, event walletEvent) int {
+		if event.Deposited != nil {
+			count++
+		}
+		return count
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, deposits)
+	assert.Equal(t, uint64(5), seq)
+
+	assert.NoError(t, acc.Close())
+	assert.ErrorIs(t, acc.Apply(deposit(1)), ErrClosed)
+}
+
+type tally struct {
+	Votes map[string]int
+}
+
+func TestEventSourced_StateCopy(t *testing.T) {
+	db := newTestStore(t)
+
+	votes, err := NewEventSourced[tally, string](db, "tallies", "poll", tally{}, func(state tally, event string) tally {
+		if state.Votes == nil {
+			state.Votes = map[string]int{}
+		}
+		state.Votes[event]++
+		return state
+	})
+	assert.NoErrorf(t, err, "error creating event-sourced container")
+	assert.NoError(t, votes.Apply("yes", "yes", "no"))
+
+	// the state returned is a copy, changing it records no event
+	state := votes.State()
+	state.Votes["no"] = 10
+
+	assert.Equal(t, map[string]int{"yes": 2, "no": 1}, votes.State().Votes)
+}
+
+func TestEventSourced_KeyPath(t *testing.T) {
+	db := newTestStore(t)
+
+	// the key names the log, it can't hold a nested path
+	_, err := NewEventSourced[wallet, walletEvent](db, "wallets", "john/savings", wallet{}, reduceWallet)
+	assert.ErrorIs(t, err, store.ErrInvalidPath)
+
+	_, err = ReadEvents[walletEvent](db, "wallets", "john/savings", 0)
+	assert.ErrorIs(t, err, store.ErrInvalidPath)
+
+	// the bucket can be nested
+	acc, err := NewEventSourced[wallet, walletEvent](db, "bank/wallets", "john", wallet{}, reduceWallet)
+	assert.NoErrorf(t, err, "error creating event-sourced container")
+	assert.NoError(t, acc.Apply(deposit(10)))
+
+	records, err := ReadEvents[walletEvent](db, "bank/wallets", "john", 0)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.NoError(t, acc.Close())
+}
@@ -0,0 +1,127 @@
+package container
+
+import (
+	"errors"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"sort"
+)
+
+type (
+	// Saveable is a container that can be saved together with others by SaveAll,
+	// it is implemented by *Container[T] for any T
+	Saveable interface {
+		groupUid() string
+		groupStore() *store.Store
+		groupLock()
+		groupUnlock()
+		groupStage() (*store.Entry, []byte, error)
+		groupCommit(snapshot []byte, rev uint64)
+		groupFail(err error)
+	}
+)
+
+// SaveAll writes the changed containers in a single transaction, they can hold different types
+// and live in different buckets. Either every container is saved or none is, a *store.ConflictError
+// is returned when any of them was written by someone else. Containers must belong to the same store
+func SaveAll(containers ...Saveable) error {
+	group := make(map[string]Saveable, len(containers))
+	for _, c := range containers {
+		group[c.groupUid()] = c
+	}
+
+	uids := make([]string, 0, len(group))
+	for uid := range group {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids) // lock in a fixed order so concurrent groups can't deadlock
+
+	if len(uids) == 0 {
+		return nil
+	}
+
+	db := group[uids[0]].groupStore()
+	for _, uid := range uids {
+		if group[uid].groupStore() != db {
+			return errors.New("containers of a save group must belong to the same store")
+		}
+	}
+
+	for _, uid := range uids {
+		group[uid].groupLock()
+		defer group[uid].groupUnlock()
+	}
+
+	var (
+		entries   []store.Entry
+		staged    []Saveable
+		snapshots [][]byte
+	)
+
+	for _, uid := range uids {
+		entry, snapshot, err := group[uid].groupStage()
+		if err != nil {
+			return err
+		}
+
+		if entry != nil {
+			entries = append(entries, *entry)
+			staged = append(staged, group[uid])
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	revs, err := db.PutMany(entries)
+	if err != nil {
+		for _, c := range staged {
+			c.groupFail(err)
+		}
+		return err
+	}
+
+	for i, c := range staged {
+		c.groupCommit(snapshots[i], revs[i])
+	}
+	return nil
+}
+
+func (c *Container[T]) groupUid() string         { return c.uid }
+func (c *Container[T]) groupStore() *store.Store { return c.db }
+func (c *Container[T]) groupLock()               { c.mu.Lock() }
+func (c *Container[T]) groupUnlock()             { c.mu.Unlock() }
+
+// groupStage returns the entry to write, nil when the container has nothing to save
+func (c *Container[T]) groupStage() (*store.Entry, []byte, error) {
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+
+	modified, err := c.changed()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.saved && !c.modified && !modified {
+		return nil, nil, nil
+	}
+	c.modified = true
+
+	data, snapshot, err := c.stage()
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+
+	entry := c.entry(data)
+	return &entry, snapshot, nil
+}
+
+func (c *Container[T]) groupCommit(snapshot []byte, rev uint64) {
+	c.commit(snapshot, rev)
+}
+
+func (c *Container[T]) groupFail(err error) {
+	_ = c.fail(err)
+}
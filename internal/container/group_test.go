@@ -0,0 +1,52 @@
+package container
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSaveAll(t *testing.T) {
+	db := newTestStore(t)
+
+	person := NewContainer[testStruct](testStruct{Name: "John", LastName: "Wick", Age: 42}, "people", "john", db,
+		WithMode(ModeExplicit), WithPolicy(Manual))
+	profile := NewContainer[nestedStruct](nestedStruct{Tags: map[string]string{"role": "hitman"}}, "profiles", "john", db,
+		WithMode(ModeExplicit), WithPolicy(Manual))
+
+	assert.NoError(t, SaveAll(person, profile, person), "error saving group")
+	assert.True(t, person.IsSaved(), "person not saved")
+	assert.True(t, profile.IsSaved(), "profile not saved")
+	assert.Equal(t, 42, readTestStruct(t, db, "people", "john").Age)
+
+	errRejected := errors.New("rejected")
+	reject := true
+	db.RegisterValidator("profiles", func(key string, value []byte) error {
+		if reject {
+			return errRejected
+		}
+		return nil
+	})
+
+	assert.NoError(t, person.Update(func(obj *testStruct) error {
+		obj.Age = 43
+		return nil
+	}))
+	assert.NoError(t, profile.Update(func(obj *nestedStruct) error {
+		obj.Scores = []int{1}
+		return nil
+	}))
+
+	assert.ErrorIs(t, SaveAll(person, profile), errRejected)
+	assert.True(t, person.IsModified(), "person marked saved after a failed group")
+	assert.True(t, profile.IsModified(), "profile marked saved after a failed group")
+	assert.ErrorIs(t, person.LastError(), errRejected)
+	assert.Equal(t, 42, readTestStruct(t, db, "people", "john").Age, "partial group written")
+
+	reject = false
+	assert.NoError(t, SaveAll(person, profile), "error saving group")
+	assert.Equal(t, 43, readTestStruct(t, db, "people", "john").Age)
+
+	assert.NoError(t, profile.Close())
+	assert.ErrorIs(t, SaveAll(person, profile), ErrClosed)
+}
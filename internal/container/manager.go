@@ -0,0 +1,79 @@
+package container
+
+import (
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"sort"
+)
+
+type (
+	// Manager gives access to every live container of a store, containers register
+	// themselves in the store when they are created and leave it when they are closed
+	Manager struct {
+		db *store.Store
+	}
+
+	// inspected is implemented by *Container[T] for any T
+	inspected interface {
+		Stats() Stats
+	}
+)
+
+// NewManager creates a manager over the containers of db
+func NewManager(db *store.Store) *Manager {
+	return &Manager{db: db}
+}
+
+// List returns the state of every live container ordered by bucket, key and uid
+func (m *Manager) List() []Stats {
+	list := make([]Stats, 0)
+	for _, item := range m.db.ListTracked() {
+		if c, ok := item.(inspected); ok {
+			list = append(list, c.Stats())
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bucket != list[j].Bucket {
+			return list[i].Bucket < list[j].Bucket
+		}
+		if list[i].Key != list[j].Key {
+			return list[i].Key < list[j].Key
+		}
+		return list[i].Uid < list[j].Uid
+	})
+
+	return list
+}
+
+// Get returns the state of the live container with the uid
+func (m *Manager) Get(uid string) (Stats, bool) {
+	for _, item := range m.db.ListTracked() {
+		if c, ok := item.(inspected); ok {
+			if stats := c.Stats(); stats.Uid == uid {
+				return stats, true
+			}
+		}
+	}
+	return Stats{}, false
+}
+
+// Dirty returns the state of the live containers with unsaved changes or a failed last write
+func (m *Manager) Dirty() []Stats {
+	dirty := make([]Stats, 0)
+	for _, stats := range m.List() {
+		if stats.Modified || !stats.Saved || stats.LastError != nil {
+			dirty = append(dirty, stats)
+		}
+	}
+	return dirty
+}
+
+// FlushAll writes the pending changes of every live container
+func (m *Manager) FlushAll() error {
+	return m.db.FlushAll()
+}
+
+// CloseAll flushes and closes every live container, the store stays open
+func (m *Manager) CloseAll() error {
+	return m.db.CloseAll()
+}
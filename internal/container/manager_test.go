@@ -0,0 +1,63 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestManager(t *testing.T) {
+	db := newTestStore(t)
+	manager := NewManager(db)
+
+	john := NewContainer[testStruct](testStruct{Name: "John"}, "people", "john", db, WithMode(ModeExplicit))
+	jane := NewContainer[testStruct](testStruct{Name: "Jane"}, "people", "jane", db, WithMode(ModeExplicit), WithPolicy(Manual))
+	assert.NoError(t, john.Save())
+
+	list := manager.List()
+	assert.Len(t, list, 2)
+	assert.Equal(t, "jane", list[0].Key)
+	assert.Equal(t, "john", list[1].Key)
+	assert.Equal(t, "container.testStruct", list[0].Type)
+
+	dirty := manager.Dirty()
+	assert.Len(t, dirty, 1)
+	assert.Equal(t, jane.GetUid(), dirty[0].Uid)
+
+	stats, ok := manager.Get(john.GetUid())
+	assert.True(t, ok)
+	assert.True(t, stats.Saved)
+
+	errRejected := errors.New("rejected")
+	reject := true
+	db.RegisterValidator("people", func(key string, value []byte) error {
+		if reject && key == "jane" {
+			return errRejected
+		}
+		return nil
+	})
+
+	assert.NoError(t, jane.Update(func(obj *testStruct) error {
+		obj.Age = 30
+		return nil
+	}))
+	assert.ErrorIs(t, manager.FlushAll(), errRejected)
+
+	stats, _ = manager.Get(jane.GetUid())
+	data, err := json.Marshal(stats)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"last_error":"rejected"`)
+
+	assert.NoError(t, john.Update(func(obj *testStruct) error {
+		obj.Age = 42
+		return nil
+	}))
+	reject = false
+	assert.NoError(t, jane.Close())
+	assert.Len(t, manager.List(), 1, "closed container still listed")
+
+	assert.NoError(t, manager.CloseAll())
+	assert.Empty(t, manager.List())
+	assert.ErrorIs(t, john.Save(), ErrClosed)
+}
@@ -0,0 +1,147 @@
+package container
+
+import "time"
+
+const (
+	// ModePolling detects changes made through GetObject on a ticker (default)
+	ModePolling Mode = iota
+	// ModeExplicit disables the polling goroutine, changes must go through Update or Save
+	ModeExplicit
+)
+
+type (
+	// Mode controls how a container detects changes to its object
+	Mode int
+
+	// Option configures a container
+	Option func(*options)
+
+	options struct {
+		mode         Mode
+		policy       Policy
+		pollInterval time.Duration
+		debounce     time.Duration
+		maxDelay     time.Duration
+		codec        Codec
+		reload       bool
+		conflict     ConflictPolicy
+		resolver     any
+		onError      func(error)
+		retries      int
+		backoff      time.Duration
+		maxBackoff   time.Duration
+
+		snapshotEvery int
+		ttl           time.Duration
+	}
+)
+
+func defaultOptions() options {
+	return options{
+		mode:         ModePolling,
+		policy:       WriteThrough,
+		pollInterval: time.Second,
+		debounce:     time.Second,
+		maxDelay:     10 * time.Second,
+		codec:        CBOR,
+		retries:      5,
+		backoff:      500 * time.Millisecond,
+		maxBackoff:   30 * time.Second,
+
+		snapshotEvery: 100,
+	}
+}
+
+// WithMode sets the change detection mode of the container
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithPollInterval sets how often a container in polling mode checks its object for changes
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
+
+// WithPolicy sets when the changes of the container are written to the store
+func WithPolicy(policy Policy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// WithWriteBehind sets the WriteBehind policy, changes are written once no change happened for
+// debounce and at the latest maxDelay after the first unsaved change
+func WithWriteBehind(debounce, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.policy = WriteBehind
+		o.debounce = debounce
+		o.maxDelay = maxDelay
+	}
+}
+
+// WithOnError sets a handler for errors of writes made in the background, transient
+// errors are reported only after the retry attempts are exhausted. Other errors, like a
+// *store.ConflictError, are reported once and the write waits for the next change or Save
+func WithOnError(fn func(error)) Option {
+	return func(o *options) {
+		o.onError = fn
+	}
+}
+
+// WithRetry sets how many times a write that failed with a transient error is retried in the
+// background before the error is reported and the initial backoff between attempts, the backoff
+// doubles on every failure. The write is still retried every max backoff after that
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithCodec sets the codec used to encode the stored object, CBOR by default
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithLiveReload makes the container reload its object when the key is written by someone else,
+// policy decides what happens when the container has unsaved changes at that moment
+func WithLiveReload(policy ConflictPolicy) Option {
+	return func(o *options) {
+		o.reload = true
+		o.conflict = policy
+	}
+}
+
+// WithConflictResolver makes the container reload its object when the key is written by someone
+// else, resolve returns the object to keep when the container has unsaved changes at that moment
+func WithConflictResolver[T any](resolve func(local, remote T) T) Option {
+	return func(o *options) {
+		o.reload = true
+		o.conflict = ResolveConflict
+		o.resolver = resolve
+	}
+}
+
+// WithSnapshotEvery sets how many events an EventSourced container appends between two snapshots
+// of its state, 100 by default, 0 only snapshots on Flush and Close
+func WithSnapshotEvery(events int) Option {
+	return func(o *options) {
+		o.snapshotEvery = events
+	}
+}
+
+// WithTTL gives the stored object a lifetime, the key expires ttl after the last write of the
+// container and is deleted by the store sweeper. Loading it afterwards returns a *NotFoundError
+// and saving the container returns a *store.ConflictError, like after a delete, the save after
+// that writes the object again
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
@@ -0,0 +1,116 @@
+package container
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// WriteThrough writes every change right away, changes made through Update are written
+	// before it returns and changes found by polling on the tick they are found (default)
+	WriteThrough Policy = iota
+	// WriteBehind writes once no change happened for the debounce delay, and at the latest
+	// max delay after the first unsaved change
+	WriteBehind
+	// Manual only writes on Save, Flush and Close
+	Manual
+)
+
+type (
+	// Policy controls when the changes of a container are written to the store
+	Policy int
+)
+
+func (p Policy) String() string {
+	switch p {
+	case WriteThrough:
+		return "write-through"
+	case WriteBehind:
+		return "write-behind"
+	case Manual:
+		return "manual"
+	default:
+		return fmt.Sprintf("policy(%d)", int(p))
+	}
+}
+
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (m Mode) String() string {
+	switch m {
+	case ModePolling:
+		return "polling"
+	case ModeExplicit:
+		return "explicit"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// persist applies a WriteBehind or Manual policy to a modified object, the caller must hold the lock
+func (c *Container[T]) persist(now time.Time) {
+	if c.opts.policy != WriteBehind {
+		return // Manual waits for Save, Flush or Close
+	}
+
+	if c.dirtySince.IsZero() {
+		c.dirtySince = now
+	}
+
+	delay := c.opts.debounce
+	if deadline := c.dirtySince.Add(c.opts.maxDelay); now.Add(delay).After(deadline) {
+		delay = deadline.Sub(now)
+	}
+
+	c.schedule(delay)
+}
+
+// schedule (re)starts the write behind timer, the caller must hold the lock
+func (c *Container[T]) schedule(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+
+	if c.timer == nil {
+		c.timer = time.AfterFunc(delay, c.writeBehind)
+		return
+	}
+
+	c.timer.Stop()
+	c.timer.Reset(delay)
+}
+
+// writeBehind writes the pending changes when the write behind timer fires
+func (c *Container[T]) writeBehind() {
+	if err := c.flushPending(); err != nil && c.opts.onError != nil {
+		c.opts.onError(err) // called without the lock so the handler can use the container
+	}
+}
+
+// flushPending writes the pending changes and reschedules the write after the retry backoff on a
+// transient failure
+func (c *Container[T]) flushPending() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	now := time.Now()
+	if err := c.flush(); err != nil {
+		report := c.failed(now, err)
+		if !c.stalled {
+			c.schedule(c.retryAt.Sub(now))
+		}
+		return report
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package container
+
+import (
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"reflect"
+)
+
+// Relate declares that the objects of type T stored in childBucket reference the keys of parentBucket
+// through their string field named field, an empty field references nothing. With store.SetNull the
+// field is emptied when the parent is deleted, the value keeps the codec it was written with
+func Relate[T any](db *store.Store, name string, parentBucket string, childBucket string, field string, onDelete store.OnDelete) error {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("relation %s: %s is not a struct", name, t)
+	}
+
+	f, ok := t.FieldByName(field)
+	if !ok || !f.IsExported() || f.Type.Kind() != reflect.String {
+		return fmt.Errorf("relation %s: %s has no exported string field %s", name, t, field)
+	}
+
+	return db.RegisterRelation(store.Relation{
+		Name:     name,
+		Parent:   parentBucket,
+		Child:    childBucket,
+		OnDelete: onDelete,
+		Ref: func(key string, value []byte) (string, error) {
+			obj := new(T)
+			if _, err := unmarshalValue(value, obj, location{childBucket, key}); err != nil {
+				return "", err
+			}
+			return reflect.ValueOf(obj).Elem().FieldByIndex(f.Index).String(), nil
+		},
+		Unset: func(key string, value []byte) ([]byte, error) {
+			codec, _, _, err := unwrap(value)
+			if err != nil {
+				return nil, err
+			}
+
+			obj := new(T)
+			if _, err = unmarshalValue(value, obj, location{childBucket, key}); err != nil {
+				return nil, err
+			}
+
+			reflect.ValueOf(obj).Elem().FieldByIndex(f.Index).SetString("")
+			return marshalValue(codec, obj, location{childBucket, key})
+		},
+	})
+}
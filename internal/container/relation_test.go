@@ -0,0 +1,63 @@
+package container
+
+import (
+	"bytes"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type (
+	transfer struct {
+		ID      string `pc:"key"`
+		Account string
+		Amount  int
+	}
+
+	card struct {
+		ID      string `pc:"key"`
+		Account string `pc:"encrypt"`
+	}
+)
+
+func TestRelate(t *testing.T) {
+	db := newTestStore(t)
+	setTestKeys(t, bytes.Repeat([]byte{1}, 32))
+
+	accounts := NewCollection[account](db, "accounts")
+	transfers := NewCollection[transfer](db, "transfers", WithCodec(JSON))
+	cards := NewCollection[card](db, "cards")
+
+	assert.Error(t, Relate[transfer](db, "transfers", "accounts", "transfers", "Amount", store.Cascade))
+	assert.NoError(t, Relate[transfer](db, "transfers", "accounts", "transfers", "Account", store.Cascade))
+	assert.NoError(t, Relate[card](db, "cards", "accounts", "cards", "Account", store.SetNull))
+
+	_, err := accounts.Insert(account{ID: "acc-1", Owner: "John"})
+	assert.NoError(t, err)
+
+	_, err = transfers.Insert(transfer{ID: "tr-1", Account: "acc-1", Amount: 10})
+	assert.NoError(t, err)
+
+	_, err = transfers.Insert(transfer{ID: "tr-2", Account: "acc-2", Amount: 10})
+	assert.ErrorIs(t, err, store.ErrMissingParent)
+
+	_, err = cards.Insert(card{ID: "card-1", Account: "acc-1"})
+	assert.NoError(t, err)
+
+	children, err := db.Children("accounts", "acc-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Child{
+		{Relation: "cards", Bucket: "cards", Key: "card-1"},
+		{Relation: "transfers", Bucket: "transfers", Key: "tr-1"},
+	}, children)
+
+	assert.NoError(t, accounts.Delete("acc-1"))
+
+	count, err := transfers.Count()
+	assert.NoError(t, err)
+	assert.Zero(t, count, "transfer not deleted with its account")
+
+	stored, err := cards.Get("card-1")
+	assert.NoError(t, err)
+	assert.Equal(t, card{ID: "card-1"}, *stored)
+}
@@ -0,0 +1,173 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"time"
+)
+
+const (
+	// LocalWins keeps the unsaved local changes, they overwrite the remote value on the next write
+	LocalWins ConflictPolicy = iota
+	// RemoteWins drops the unsaved local changes and loads the remote value
+	RemoteWins
+	// ResolveConflict calls the resolver set with WithConflictResolver to merge both values
+	ResolveConflict
+)
+
+type (
+	// ConflictPolicy decides what a live container does when its key is written by someone
+	// else while the container has unsaved changes
+	ConflictPolicy int
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case LocalWins:
+		return "local-wins"
+	case RemoteWins:
+		return "remote-wins"
+	case ResolveConflict:
+		return "resolve"
+	default:
+		return fmt.Sprintf("conflict(%d)", int(p))
+	}
+}
+
+// subscribe reloads the container when its key is written by someone else
+func (c *Container[T]) subscribe() {
+	c.unsubscribe = c.db.Subscribe(c.bucketName, c.key, func(event store.Event) {
+		if err := c.reload(event); err != nil && c.opts.onError != nil {
+			c.opts.onError(err)
+		}
+	})
+}
+
+// reload applies a change made to the key by someone else
+func (c *Container[T]) reload(event store.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the container already knows revisions up to seen, including the ones it wrote itself
+	if c.closed || event.Revision <= c.seen {
+		return nil
+	}
+	c.seen = event.Revision
+
+	if event.Op == store.OpDelete {
+		// the object stays in memory and is written again on the next save
+		c.gone()
+		return nil
+	}
+
+	// the remote value is the one local changes are written over
+	c.revision = event.Revision
+
+	var remote T
+	if _, err := unmarshalValue(event.Value, &remote, location{c.bucketName, c.key}); err != nil {
+		return fmt.Errorf("reloading %s/%s: %w", c.bucketName, c.key, err)
+	}
+
+	remoteSnapshot, err := encMode.Marshal(&remote)
+	if err != nil {
+		return err
+	}
+
+	dirty, err := c.changed()
+	if err != nil {
+		return err
+	}
+
+	c.saved = true
+
+	if !dirty && !c.modified {
+		c.apply(remote, remoteSnapshot)
+		return nil
+	}
+
+	switch c.opts.conflict {
+	case RemoteWins:
+		c.apply(remote, remoteSnapshot)
+		return nil
+	case ResolveConflict:
+		if resolve, ok := c.opts.resolver.(func(local, remote T) T); ok {
+			c.item = resolve(c.item, remote)
+		}
+	}
+
+	// the local object is compared against the remote value from now on
+	c.snapshot = remoteSnapshot
+
+	if c.modified, err = c.changed(); err != nil || !c.modified {
+		return err
+	}
+
+	if c.opts.policy == WriteThrough {
+		return c.set()
+	}
+
+	c.persist(time.Now())
+	return nil
+}
+
+// Refresh drops the unsaved changes and loads the object stored in the database, it is the
+// way to recover from a *store.ConflictError returned by Save. When the key was deleted or
+// has expired the object is kept, a *NotFoundError is returned and the next save writes it again
+func (c *Container[T]) Refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	data, rev, err := c.db.GetWithRevision(c.bucketName, c.key)
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 {
+		c.gone()
+		return &NotFoundError{Bucket: c.bucketName, Key: c.key}
+	}
+
+	var remote T
+	migrated, err := unmarshalValue(data, &remote, location{c.bucketName, c.key})
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := encMode.Marshal(&remote)
+	if err != nil {
+		return err
+	}
+
+	c.apply(remote, snapshot)
+	if migrated {
+		c.snapshot = nil // written back in the current schema on the next save
+	}
+
+	c.saved = true
+	c.revision = rev
+	c.seen = max(c.seen, rev)
+	c.lastErr = nil
+	return nil
+}
+
+// apply replaces the object with the remote value, the caller must hold the lock
+func (c *Container[T]) apply(remote T, snapshot []byte) {
+	if !bytes.Equal(snapshot, c.observed) {
+		c.notify(SourceRemote, c.observed, snapshot)
+	}
+
+	c.item = remote
+	c.snapshot = snapshot
+	c.observed = snapshot
+	c.modified = false
+	c.dirtySince = time.Time{}
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
@@ -0,0 +1,100 @@
+package container
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func putRemote(t *testing.T, container *Container[testStruct], obj testStruct) {
+	data, err := marshalValue(CBOR, &obj, location{container.bucketName, container.key})
+	assert.NoError(t, err)
+	assert.NoError(t, container.db.Put(container.bucketName, container.key, data))
+}
+
+func waitRemote(t *testing.T, changes <-chan Change[testStruct]) Change[testStruct] {
+	for {
+		select {
+		case change := <-changes:
+			if change.Source == SourceRemote {
+				return change
+			}
+		case <-time.After(time.Second):
+			t.Fatal("remote change not received")
+		}
+	}
+}
+
+func TestContainer_LiveReload(t *testing.T) {
+	db := newTestStore(t)
+
+	setAge := func(obj *testStruct) error {
+		obj.Age = 42
+		return nil
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "cleanKey", db, WithMode(ModeExplicit), WithLiveReload(LocalWins))
+		changes := container.Watch(context.Background())
+
+		assert.NoError(t, container.Update(setAge))
+		putRemote(t, container, testStruct{Name: "Jonathan", Age: 43})
+
+		change := waitRemote(t, changes)
+		assert.Equal(t, 42, change.Old.Age)
+		assert.Equal(t, testStruct{Name: "Jonathan", Age: 43}, *container.GetObject())
+		assert.False(t, container.IsModified())
+	})
+
+	t.Run("remote wins", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "remoteKey", db,
+			WithMode(ModeExplicit), WithPolicy(Manual), WithLiveReload(RemoteWins))
+		changes := container.Watch(context.Background())
+
+		assert.NoError(t, container.Update(setAge))
+		putRemote(t, container, testStruct{Name: "Jonathan"})
+
+		waitRemote(t, changes)
+		assert.Equal(t, testStruct{Name: "Jonathan"}, *container.GetObject())
+		assert.False(t, container.IsModified())
+	})
+
+	t.Run("local wins", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "localKey", db,
+			WithMode(ModeExplicit), WithLiveReload(LocalWins))
+		changes := container.Watch(context.Background())
+
+		assert.NoError(t, container.Save())
+		container.GetObject().Age = 42 // not written yet in explicit mode
+		putRemote(t, container, testStruct{Name: "Jonathan"})
+
+		// the local change is written over the remote value
+		assert.Eventually(t, func() bool {
+			return readTestStruct(t, db, "test", "localKey").Age == 42
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "John", readTestStruct(t, db, "test", "localKey").Name)
+
+		for len(changes) > 0 {
+			assert.NotEqual(t, SourceRemote, (<-changes).Source)
+		}
+	})
+
+	t.Run("resolver", func(t *testing.T) {
+		container := NewContainer[testStruct](testStruct{Name: "John"}, "test", "resolveKey", db,
+			WithMode(ModeExplicit), WithPolicy(Manual),
+			WithConflictResolver(func(local, remote testStruct) testStruct {
+				remote.Age = local.Age
+				return remote
+			}))
+
+		assert.NoError(t, container.Update(setAge))
+		putRemote(t, container, testStruct{Name: "Jonathan"})
+
+		assert.Eventually(t, func() bool {
+			return container.Stats().Saved
+		}, time.Second, 10*time.Millisecond)
+		assert.NoError(t, container.Save())
+		assert.Equal(t, testStruct{Name: "Jonathan", Age: 42}, readTestStruct(t, db, "test", "resolveKey"))
+	})
+}
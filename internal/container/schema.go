@@ -0,0 +1,178 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrGobSchema is returned when a type with a registered schema is written or migrated with the
+// Gob codec, gob can't decode a value into the document the migrations work on
+var ErrGobSchema = errors.New("gob values can't be migrated")
+
+type (
+	// Migration upgrades a decoded value by one schema version, the document holds
+	// the value as decoded by its codec before it is turned into the current type
+	Migration func(doc map[string]any) error
+
+	schema struct {
+		name       string
+		migrations []Migration
+		migrate    func(db *store.Store, bucketName string) (int, error)
+	}
+)
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = map[reflect.Type]*schema{}
+	byName    = map[string]*schema{}
+)
+
+// RegisterSchema declares the current schema version of T as len(migrations)+1, migrations[0]
+// upgrades values from version 1 to 2, migrations[1] from 2 to 3 and so on. Values written
+// before T was registered are version 1, the name is used by the migrate command. T can't be
+// stored with the Gob codec, writing it returns ErrGobSchema
+func RegisterSchema[T any](name string, migrations ...Migration) {
+	s := &schema{
+		name:       name,
+		migrations: migrations,
+		migrate: func(db *store.Store, bucketName string) (int, error) {
+			return MigrateBucket[T](db, bucketName)
+		},
+	}
+
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	schemas[reflect.TypeFor[T]()] = s
+	byName[name] = s
+}
+
+// unregisterSchema removes the schema of T, values are read as version 1 again
+func unregisterSchema[T any]() {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	if s, ok := schemas[reflect.TypeFor[T]()]; ok {
+		delete(byName, s.name)
+		delete(schemas, reflect.TypeFor[T]())
+	}
+}
+
+// SchemaVersion returns the current schema version of T
+func SchemaVersion[T any]() uint64 {
+	return schemaVersion(reflect.TypeFor[T]())
+}
+
+// SchemaNames returns the names of the registered schemas
+func SchemaNames() []string {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MigrateSchema rewrites every value of bucketName that is older than the current version
+// of the schema registered under name, it returns the number of values rewritten
+func MigrateSchema(db *store.Store, name string, bucketName string) (int, error) {
+	schemasMu.RLock()
+	s, ok := byName[name]
+	schemasMu.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("schema %s not registered", name)
+	}
+	return s.migrate(db, bucketName)
+}
+
+// MigrateBucket rewrites every value of bucketName older than the current version of T,
+// values keep the codec they were written with
+func MigrateBucket[T any](db *store.Store, bucketName string) (int, error) {
+	keys, values, err := db.GetBucketKeysValues(bucketName)
+	if err != nil {
+		return 0, err
+	}
+
+	current := SchemaVersion[T]()
+	migrated := 0
+
+	for i, value := range values {
+		codec, version, _, err := unwrap(value)
+		if err != nil {
+			return migrated, fmt.Errorf("key %s: %w", keys[i], err)
+		}
+
+		if version >= current {
+			continue
+		}
+
+		var obj T
+		at := location{bucketName, string(keys[i])}
+		if _, err = unmarshalValue(value, &obj, at); err != nil {
+			return migrated, fmt.Errorf("key %s: %w", keys[i], err)
+		}
+
+		data, err := marshalValue(codec, &obj, at)
+		if err != nil {
+			return migrated, fmt.Errorf("key %s: %w", keys[i], err)
+		}
+
+		if err = db.Put(bucketName, string(keys[i]), data); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+func lookupSchema(t reflect.Type) *schema {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+	return schemas[t]
+}
+
+// schemaVersion returns the current version of the type, 1 if it isn't registered
+func schemaVersion(t reflect.Type) uint64 {
+	if s := lookupSchema(t); s != nil {
+		return uint64(len(s.migrations)) + 1
+	}
+	return 1
+}
+
+// migrate decodes the payload as a document, runs the migrations from version up to the
+// current one and decodes the result into v
+func migrate(s *schema, codec Codec, payload []byte, version uint64, v any) error {
+	if version == 0 || version > uint64(len(s.migrations))+1 {
+		return fmt.Errorf("schema %s has no version %d", s.name, version)
+	}
+
+	if codec.ID() == CodecGob {
+		return fmt.Errorf("schema %s: %w", s.name, ErrGobSchema)
+	}
+
+	var doc map[string]any
+	if err := codec.Unmarshal(payload, &doc); err != nil {
+		return fmt.Errorf("schema %s: migrating %s values: %w", s.name, codec.Name(), err)
+	}
+
+	for i := version - 1; i < uint64(len(s.migrations)); i++ {
+		if err := s.migrations[i](doc); err != nil {
+			return fmt.Errorf("schema %s: migration from version %d: %w", s.name, i+1, err)
+		}
+	}
+
+	data, err := codec.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
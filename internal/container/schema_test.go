@@ -0,0 +1,93 @@
+package container
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type invoice struct {
+	Number string
+}
+
+type orderV1 struct {
+	Customer string
+	Total    int
+}
+
+type order struct {
+	CustomerName string
+	TotalCents   int
+	Currency     string
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	db := newTestStore(t)
+
+	// values written before the schema of order existed are version 1
+	for _, key := range []string{"o1", "o2"} {
+		c := NewContainer[orderV1](orderV1{Customer: "John", Total: 12}, "orders", key, db, WithMode(ModeExplicit))
+		assert.NoErrorf(t, c.Save(), "error saving container")
+	}
+
+	RegisterSchema[order]("order",
+		func(doc map[string]any) error {
+			doc["CustomerName"] = doc["Customer"]
+			delete(doc, "Customer")
+			return nil
+		},
+		func(doc map[string]any) error {
+			doc["TotalCents"] = doc["Total"]
+			doc["Currency"] = "EUR"
+			delete(doc, "Total")
+			return nil
+		},
+	)
+	t.Cleanup(unregisterSchema[order])
+
+	assert.Equal(t, uint64(3), SchemaVersion[order]())
+	assert.Contains(t, SchemaNames(), "order")
+
+	loaded, err := LoadContainer[order](db, "orders", "o1", WithMode(ModeExplicit))
+	assert.NoErrorf(t, err, "error loading container")
+	assert.Equal(t, order{CustomerName: "John", TotalCents: 12, Currency: "EUR"}, *loaded.GetObject())
+
+	// the migrated object is written back in the current schema on the next flush
+	assert.NoErrorf(t, loaded.Flush(), "error flushing container")
+
+	migrated, err := MigrateSchema(db, "order", "orders")
+	assert.NoErrorf(t, err, "error migrating bucket")
+	assert.Equal(t, 1, migrated, "only o2 should be left to migrate")
+
+	data, err := db.Get("orders", "o2")
+	assert.NoErrorf(t, err, "error getting key")
+
+	_, version, _, err := unwrap(data)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+
+	_, err = MigrateSchema(db, "unknown", "orders")
+	assert.Error(t, err)
+}
+
+func TestSchemaGob(t *testing.T) {
+	db := newTestStore(t)
+
+	c := NewContainer[invoice](invoice{Number: "F-1"}, "invoices", "i1", db, WithMode(ModeExplicit), WithCodec(Gob))
+	assert.NoErrorf(t, c.Save(), "error saving container")
+	assert.NoError(t, c.Close())
+
+	RegisterSchema[invoice]("invoice", func(doc map[string]any) error {
+		return nil
+	})
+	t.Cleanup(unregisterSchema[invoice])
+
+	_, err := LoadContainer[invoice](db, "invoices", "i1", WithMode(ModeExplicit))
+	assert.ErrorIs(t, err, ErrGobSchema)
+
+	_, err = MigrateBucket[invoice](db, "invoices")
+	assert.ErrorIs(t, err, ErrGobSchema)
+
+	c = NewContainer[invoice](invoice{Number: "F-2"}, "invoices", "i2", db, WithMode(ModeExplicit), WithCodec(Gob))
+	assert.ErrorIs(t, c.Save(), ErrGobSchema)
+	assert.ErrorIs(t, c.Close(), ErrGobSchema)
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// SourceLocal is a change made to the object in memory, through Update or found by polling
+	SourceLocal ChangeSource = iota
+	// SourcePersist is a change written to the store
+	SourcePersist
+	// SourceRemote is a change written to the store by someone else and loaded by a live container
+	SourceRemote
+)
+
+// watchBuffer is the number of changes kept for a watcher that isn't receiving,
+// changes are dropped once the buffer is full
+const watchBuffer = 64
+
+type (
+	// ChangeSource tells where a change comes from
+	ChangeSource int
+
+	// Change is sent to watchers when the object of a container changes, Old and New are
+	// copies that don't share maps, slices or pointers with the object of the container
+	Change[T any] struct {
+		Old    T
+		New    T
+		Source ChangeSource
+	}
+)
+
+func (s ChangeSource) String() string {
+	switch s {
+	case SourceLocal:
+		return "local"
+	case SourcePersist:
+		return "persist"
+	case SourceRemote:
+		return "remote"
+	default:
+		return fmt.Sprintf("source(%d)", int(s))
+	}
+}
+
+// Watch returns a channel receiving the changes of the object until ctx is done or the
+// container is closed, then the channel is closed. Changes are dropped for watchers that
+// fall more than 64 changes behind
+func (c *Container[T]) Watch(ctx context.Context) <-chan Change[T] {
+	ch := make(chan Change[T], watchBuffer)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		close(ch)
+		return ch
+	}
+
+	c.watchers = append(c.watchers, ch)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.ctx.Done():
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for i, watcher := range c.watchers {
+			if watcher == ch {
+				c.watchers = append(c.watchers[:i], c.watchers[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// notify sends a change to the watchers without blocking, prev and next are canonical cbor
+// encodings of the object, the caller must hold the lock
+func (c *Container[T]) notify(source ChangeSource, prev, next []byte) {
+	if len(c.watchers) == 0 {
+		return
+	}
+
+	change := Change[T]{Source: source}
+	if len(prev) > 0 {
+		if err := cbor.Unmarshal(prev, &change.Old); err != nil {
+			return
+		}
+	}
+
+	if err := cbor.Unmarshal(next, &change.New); err != nil {
+		return
+	}
+
+	for _, watcher := range c.watchers {
+		select {
+		case watcher <- change:
+		default:
+		}
+	}
+}
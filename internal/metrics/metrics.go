@@ -6,6 +6,7 @@ import (
 	"errors"
 	bolt "go.etcd.io/bbolt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,8 +20,24 @@ type (
 		mu            sync.RWMutex
 		ctx           context.Context
 		bucketName    []byte
-		readsCh       chan int64
-		writesCh      chan int64
+		counters      counters
+		ticked        ticked
+	}
+
+	// counters are updated without locking by every read and write of the store, a transaction
+	// only adds to its own counter. The exported fields are a snapshot of them taken when the
+	// metrics are saved or read, LastUpdate is the time of that snapshot
+	counters struct {
+		reads      atomic.Int64
+		writes     atomic.Int64
+		readBytes  atomic.Int64
+		writeBytes atomic.Int64
+	}
+
+	// ticked holds the totals at the last tick, the rates are the difference with them
+	ticked struct {
+		reads  int64
+		writes int64
 	}
 
 	SystemMetrics struct {
@@ -47,8 +64,6 @@ func NewMetrics(ctx context.Context, db *bolt.DB) *Metrics {
 		ctx:        ctx,
 		db:         db,
 		bucketName: []byte("metrics"),
-		readsCh:    make(chan int64, 100),
-		writesCh:   make(chan int64, 100),
 		Uptime:     time.Now(),
 		LastUpdate: time.Now(),
 		SystemMetrics: SystemMetrics{
@@ -91,13 +106,19 @@ func (m *Metrics) startMonitor() {
 			}
 
 			m.mu.Lock()
-			err := json.Unmarshal(data, m)
-			m.mu.Unlock()
+			defer m.mu.Unlock()
 
-			if err != nil {
+			if err := json.Unmarshal(data, m); err != nil {
 				return errors.New("failed to unmarshal metrics data: " + err.Error())
 			}
 
+			// the totals carry on from the saved ones
+			m.counters.reads.Add(m.Iops.TotalReads)
+			m.counters.writes.Add(m.Iops.TotalWrites)
+			m.counters.readBytes.Add(m.Iops.TotalReadBytes)
+			m.counters.writeBytes.Add(m.Iops.TotalWriteBytes)
+			m.ticked.reads += m.Iops.TotalReads
+			m.ticked.writes += m.Iops.TotalWrites
 			return nil
 		})
 		if err != nil {
@@ -113,24 +134,27 @@ func (m *Metrics) startMonitor() {
 			return
 		case <-ticker.C:
 			m.resetIopsRates()
-		case v := <-m.readsCh:
-			m.incrementTotalReadBytes(v)
-		case v := <-m.writesCh:
-			m.incrementTotalWriteBytes(v)
 		}
 	}
 }
 
+// resetIopsRates takes the rates of the last second and saves the metrics
 func (m *Metrics) resetIopsRates() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.snapshot()
+
+	m.Iops.ReadsPerSecond = m.Iops.TotalReads - m.ticked.reads
+	m.Iops.WritesPerSecond = m.Iops.TotalWrites - m.ticked.writes
+	m.ticked.reads, m.ticked.writes = m.Iops.TotalReads, m.Iops.TotalWrites
+
 	saveData, err := json.Marshal(m)
 	if err != nil {
 		return
 	}
 
-	err = m.db.Update(func(tx *bolt.Tx) error {
+	_ = m.db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(m.bucketName)
 		if err != nil {
 			return err
@@ -138,26 +162,25 @@ func (m *Metrics) resetIopsRates() {
 
 		return bucket.Put(m.bucketName, saveData)
 	})
-	if err != nil {
-		return
-	}
-
-	m.Iops.ReadsPerSecond = 0
-	m.Iops.WritesPerSecond = 0
 }
 
-func (m *Metrics) incrementTotalReadBytes(v int64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.Iops.TotalReadBytes += v
+// snapshot copies the counters to the exported fields, m.mu must be held
+func (m *Metrics) snapshot() {
+	m.LastUpdate = time.Now()
+	m.Iops.TotalReads = m.counters.reads.Load()
+	m.Iops.TotalWrites = m.counters.writes.Load()
+	m.Iops.TotalReadBytes = m.counters.readBytes.Load()
+	m.Iops.TotalWriteBytes = m.counters.writeBytes.Load()
 }
 
-func (m *Metrics) incrementTotalWriteBytes(v int64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// AddReadBytes counts bytes read from the database
+func (m *Metrics) AddReadBytes(v int64) {
+	m.counters.readBytes.Add(v)
+}
 
-	m.Iops.TotalWriteBytes += v
+// AddWriteBytes counts bytes written to the database
+func (m *Metrics) AddWriteBytes(v int64) {
+	m.counters.writeBytes.Add(v)
 }
 
 func (m *Metrics) UpdateMetrics(tx *bolt.Tx) {
@@ -168,49 +191,29 @@ func (m *Metrics) UpdateMetrics(tx *bolt.Tx) {
 	m.SystemMetrics.Stats = &stats
 }
 
+// UpdateIopsReads counts a read transaction, it doesn't lock so reads stay concurrent
 func (m *Metrics) UpdateIopsReads() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.Iops.TotalReads++
-	m.Iops.ReadsPerSecond++
+	m.counters.reads.Add(1)
 }
 
+// UpdateIopsWrites counts a write transaction
 func (m *Metrics) UpdateIopsWrites() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.Iops.TotalWrites++
-	m.Iops.WritesPerSecond++
+	m.counters.writes.Add(1)
 }
 
+// GetMetrics counts a read and returns a copy of the current metrics
 func (m *Metrics) GetMetrics() (*Metrics, error) {
-	err := m.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(m.bucketName)
-		if bucket == nil {
-			return errors.New("metrics bucket not found")
-		}
-
-		data := bucket.Get(m.bucketName)
-		if data == nil {
-			return errors.New("metrics key not found")
-		}
-
-		m.mu.Lock()
-		err := json.Unmarshal(data, m)
-		m.mu.Unlock()
-
-		if err != nil {
-			return errors.New("failed to unmarshal metrics data: " + err.Error())
-		}
+	m.UpdateIopsReads()
 
-		m.mu.Lock()
-		m.LastUpdate = time.Now()
-		m.Iops.TotalReads++
-		m.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		return nil
-	})
+	m.snapshot()
 
-	return m, err
+	return &Metrics{
+		Uptime:        m.Uptime,
+		LastUpdate:    m.LastUpdate,
+		SystemMetrics: m.SystemMetrics,
+		Iops:          m.Iops,
+	}, nil
 }
@@ -4,50 +4,63 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/caarlos0/log"
 	"github.com/dyammarcano/persistent-container/internal/algorithm/compression"
 	"github.com/dyammarcano/persistent-container/internal/cache2you"
+	"github.com/dyammarcano/persistent-container/internal/container"
 	vue "github.com/dyammarcano/persistent-container/internal/monitoring/ui-store"
 	"github.com/dyammarcano/persistent-container/internal/owner"
 	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
 	"github.com/dyammarcano/persistent-container/internal/version"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	bucketNotFound = "bucket not found"
+	bucketNotFound  = "bucket not found"
+	shutdownTimeout = 10 * time.Second
+	maxPageSize     = 1000
+
+	nextCursorHeader = "X-Next-Cursor"
 )
 
+var errIfMatch = errors.New("if-match must hold a single strong entity tag")
+
 type (
 	Monitoring struct {
-		wg        sync.WaitGroup
-		err       chan error
-		port      string
-		router    *gin.Engine
-		ctx       context.Context
-		db        *store.Store
-		cacheFs   *cache2you.FS
-		cacheData *cache2you.Data
+		wg         sync.WaitGroup
+		err        chan error
+		port       string
+		router     *gin.Engine
+		ctx        context.Context
+		db         *store.Store
+		containers *container.Manager
+		cacheFs    *cache2you.FS
+		cacheData  *cache2you.Data
 	}
 )
 
 func NewMonitoring(ctx context.Context, db *store.Store, port int) *Monitoring {
 	m := &Monitoring{
-		wg:        sync.WaitGroup{},
-		err:       make(chan error),
-		port:      fmt.Sprintf(":%d", port),
-		ctx:       ctx,
-		router:    gin.New(),
-		cacheFs:   cache2you.NewCacheFS(vue.AssetsFiles, 24*time.Hour),
-		cacheData: cache2you.NewCacheData(5*time.Minute, 10*time.Minute),
-		db:        db,
+		wg:         sync.WaitGroup{},
+		err:        make(chan error, 1),
+		port:       fmt.Sprintf(":%d", port),
+		ctx:        ctx,
+		router:     gin.New(),
+		cacheFs:    cache2you.NewCacheFS(vue.AssetsFiles, 24*time.Hour),
+		cacheData:  cache2you.NewCacheData(5*time.Minute, 10*time.Minute),
+		db:         db,
+		containers: container.NewManager(db),
 	}
 
 	m.router.Use(m.hacks(m.ctx)) // for demo purposes, please don't do this in production
@@ -55,6 +68,7 @@ func NewMonitoring(ctx context.Context, db *store.Store, port int) *Monitoring {
 
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3000/"}
+	config.ExposeHeaders = []string{nextCursorHeader}
 
 	m.router.Use(cors.New(config))
 
@@ -202,6 +216,7 @@ func (m *Monitoring) apiAuth() gin.HandlerFunc {
 
 			if token.IsValid() {
 				c.Set("bucket", token.Bucket)
+				c.Set("owner", token.Owner)
 				c.Next()
 				return
 			}
@@ -218,18 +233,70 @@ func (m *Monitoring) apiAuth() gin.HandlerFunc {
 		}
 
 		c.Set("bucket", token.Bucket)
+		c.Set("owner", token.Owner)
 		m.cacheData.Set(encToken, token, cache2you.NoExpiration)
 		c.Next()
 	}
 }
 
+// adminAuth is a middleware that only lets through the tokens of owners with the admin permission,
+// it must run after apiAuth
+func (m *Monitoring) adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		holder, _ := c.Get("owner")
+		if holder, ok := holder.(*owner.Owner); !ok || holder == nil || holder.Permissions == nil || !holder.Permissions.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin permission required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// bucketPath is a middleware that moves the request to the bucket nested in the bucket of the token
+// at ?path=, such as orders/2024, the buckets the store keeps for itself can't be reached
+func (m *Monitoring) bucketPath() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Query("path")
+		if path == "" {
+			c.Next()
+			return
+		}
+
+		if err := store.ValidatePath(path); err != nil {
+			storeError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Set("bucket", c.GetString("bucket")+store.PathSeparator+path)
+		c.Next()
+	}
+}
+
 func (m *Monitoring) routes() {
-	v1 := m.router.Group("/api/v1", m.apiAuth())
+	v1 := m.router.Group("/api/v1", m.apiAuth(), m.bucketPath())
 
 	v1.GET("/data", m.dataHandler)
 	v1.GET("/data/:id", m.dataIDHandler)
 	v1.POST("/data", m.postDataHandler)
+	v1.PUT("/data/:id", m.putIDHandler)
 	v1.DELETE("/data/:id", m.deleteIDHandler)
+	v1.GET("/data/:id/children", m.childrenHandler)
+
+	v1.GET("/buckets", m.bucketsHandler)
+	v1.DELETE("/buckets", m.deleteBucketHandler)
+
+	v1.POST("/blobs", m.postBlobHandler)
+	v1.GET("/blobs/:id", m.blobHandler)
+	v1.PUT("/blobs/:id", m.putBlobHandler)
+	v1.DELETE("/blobs/:id", m.deleteBlobHandler)
+
+	admin := m.router.Group("/admin", m.apiAuth(), m.adminAuth())
+
+	admin.GET("/containers", m.containersHandler)
+	admin.GET("/containers/:uid", m.containerHandler)
+	admin.POST("/containers/flush", m.flushContainersHandler)
 
 	m.router.GET("/", m.rootHandler)
 	m.router.GET("/metrics", m.metricsHandler)
@@ -257,6 +324,11 @@ func (m *Monitoring) dataHandler(c *gin.Context) {
 		return
 	}
 
+	if c.Query("limit") != "" || c.Query("cursor") != "" {
+		m.pageHandler(c, bucket)
+		return
+	}
+
 	data, err := m.db.GetBucketKeys(bucket)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -265,6 +337,35 @@ func (m *Monitoring) dataHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 }
 
+// pageHandler lists a page of keys like the unpaged listing, the cursor of the following page is
+// sent in the X-Next-Cursor header and is omitted on the last one
+func (m *Monitoring) pageHandler(c *gin.Context, bucket string) {
+	limit := store.DefaultScanLimit
+	if c.Query("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(c.Query("limit")); err != nil || limit < 1 || limit > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+	}
+
+	page, err := m.db.Scan(bucket, store.ScanOptions{After: c.Query("cursor"), Limit: limit})
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	keys := make([]store.Key, 0, len(page.Items))
+	for _, item := range page.Items {
+		keys = append(keys, store.Key{Key: item.Key})
+	}
+
+	if page.Next != "" {
+		c.Header(nextCursorHeader, page.Next)
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
 func (m *Monitoring) dataIDHandler(c *gin.Context) {
 	id := c.Param("id")
 
@@ -279,27 +380,130 @@ func (m *Monitoring) dataIDHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := m.db.Get(bucket, id)
+	data, rev, err := m.db.GetWithRevision(bucket, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if data == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+
 	dec, err := compression.DecompressData(data)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if dec == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "bucket empty"})
+	c.Header("ETag", etag(rev))
+	c.JSON(http.StatusOK, gin.H{"data": string(dec)})
+}
+
+func (m *Monitoring) deleteIDHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if len(id) != 36 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id length"})
+		return
+	}
+
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": string(dec)})
+	rev, conditional, err := m.ifMatch(c, bucket, id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	if conditional {
+		err = m.db.DeleteIfRevision(bucket, id, rev)
+	} else {
+		err = m.db.DeleteKey(bucket, id)
+	}
+
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
-func (m *Monitoring) deleteIDHandler(c *gin.Context) {
+// bucketsHandler returns the stats and the child buckets of the bucket at ?path=
+func (m *Monitoring) bucketsHandler(c *gin.Context) {
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
+		return
+	}
+
+	stats, err := m.db.BucketStats(bucket)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	buckets, err := m.db.ListBuckets(bucket)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	stats.Path = c.Query("path")
+	c.JSON(http.StatusOK, gin.H{"stats": stats, "buckets": buckets})
+}
+
+// deleteBucketHandler deletes the bucket at ?path= with every bucket nested in it, the bucket
+// of the token itself can't be deleted
+func (m *Monitoring) deleteBucketHandler(c *gin.Context) {
+	if c.Query("path") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	if err := m.db.DeleteBucket(c.GetString("bucket")); err != nil {
+		storeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// childrenHandler lists the keys that reference the key through the relations of the store
+func (m *Monitoring) childrenHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if len(id) != 36 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id length"})
+		return
+	}
+
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
+		return
+	}
+
+	children, err := m.db.Children(bucket, id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	if children == nil {
+		children = []store.Child{}
+	}
+	c.JSON(http.StatusOK, children)
+}
+
+// putIDHandler replaces a value, the request must hold the ETag of the value it replaces in If-Match
+func (m *Monitoring) putIDHandler(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) != 36 {
@@ -313,12 +517,82 @@ func (m *Monitoring) deleteIDHandler(c *gin.Context) {
 		return
 	}
 
-	if err := m.db.DeleteKey(bucket, id); err != nil {
+	rev, conditional, err := m.ifMatch(c, bucket, id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	if !conditional {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "if-match header required"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = validateBody(c.ContentType(), body); err != nil {
+		storeError(c, err)
+		return
+	}
+
+	comp, err := compression.CompressData(body)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	if rev, err = m.db.PutIfRevision(bucket, id, comp, rev); err != nil {
+		storeError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(rev))
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+// ifMatch returns the revision required by the If-Match header, conditional is false when the
+// request has none. With * the current revision is required, so the key must exist
+func (m *Monitoring) ifMatch(c *gin.Context, bucket, id string) (rev uint64, conditional bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return 0, false, nil
+	}
+
+	if header == "*" {
+		if rev, err = m.db.GetRevision(bucket, id); err != nil {
+			return 0, false, err
+		}
+
+		if rev == 0 {
+			return 0, false, &store.ConflictError{Bucket: bucket, Key: id}
+		}
+		return rev, true, nil
+	}
+
+	// weak tags never match, If-Match uses the strong comparison
+	if strings.HasPrefix(header, "W/") {
+		return ^uint64(0), true, nil
+	}
+
+	value, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, false, errIfMatch
+	}
+
+	if rev, err = strconv.ParseUint(value, 10, 64); err != nil {
+		// no revision has this tag, the write is checked against one that can't match
+		return ^uint64(0), true, nil
+	}
+	return rev, true, nil
+}
+
+// etag formats a revision as a strong entity tag
+func etag(rev uint64) string {
+	return strconv.Quote(strconv.FormatUint(rev, 10))
 }
 
 func (m *Monitoring) postDataHandler(c *gin.Context) {
@@ -328,6 +602,11 @@ func (m *Monitoring) postDataHandler(c *gin.Context) {
 		return
 	}
 
+	if err = validateBody(c.ContentType(), body); err != nil {
+		storeError(c, err)
+		return
+	}
+
 	comp, err := compression.CompressData(body)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -341,14 +620,177 @@ func (m *Monitoring) postDataHandler(c *gin.Context) {
 	}
 
 	id := store.GenerateKey()
-	if err = m.db.Put(bucket, id, comp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	rev, err := m.db.PutIfRevision(bucket, id, comp, 0)
+	if err != nil {
+		storeError(c, err)
 		return
 	}
 
+	c.Header("ETag", etag(rev))
 	c.JSON(http.StatusOK, gin.H{"id": id})
 }
 
+// postBlobHandler streams the body into a new blob, it is never held in memory as a whole
+func (m *Monitoring) postBlobHandler(c *gin.Context) {
+	m.writeBlob(c, store.GenerateKey())
+}
+
+// putBlobHandler streams the body into the blob, replacing it
+func (m *Monitoring) putBlobHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if len(id) != 36 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id length"})
+		return
+	}
+
+	m.writeBlob(c, id)
+}
+
+func (m *Monitoring) writeBlob(c *gin.Context, id string) {
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
+		return
+	}
+
+	manifest, err := m.db.PutBlob(bucket, id, c.Request.Body, store.BlobOptions{ContentType: c.ContentType()})
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(manifest.Revision))
+	c.JSON(http.StatusOK, gin.H{"id": id, "size": manifest.Size})
+}
+
+// blobHandler streams a blob, Range, If-Range and If-None-Match are handled by http.ServeContent
+func (m *Monitoring) blobHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if len(id) != 36 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id length"})
+		return
+	}
+
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
+		return
+	}
+
+	blob, err := m.db.OpenBlob(bucket, id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	manifest := blob.Manifest()
+	if manifest.ContentType != "" {
+		c.Header("Content-Type", manifest.ContentType)
+	}
+	c.Header("ETag", etag(manifest.Revision))
+
+	http.ServeContent(c.Writer, c.Request, "", manifest.ModTime, blob)
+}
+
+// deleteBlobHandler deletes a blob with its chunks
+func (m *Monitoring) deleteBlobHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if len(id) != 36 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id length"})
+		return
+	}
+
+	bucket := c.GetString("bucket")
+	if bucket == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": bucketNotFound})
+		return
+	}
+
+	if err := m.db.DeleteBlob(bucket, id); err != nil {
+		storeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// validateBody rejects empty bodies and json bodies that don't parse
+func validateBody(contentType string, body []byte) error {
+	if len(body) == 0 {
+		return validation.NewError("body", "must not be empty")
+	}
+
+	if contentType == gin.MIMEJSON && !json.Valid(body) {
+		return validation.NewError("body", "must be valid json")
+	}
+	return nil
+}
+
+// storeError writes the status matching an error returned by the store
+func storeError(c *gin.Context, err error) {
+	var validationErr *validation.Error
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "validation failed", "fields": validationErr.Fields})
+		return
+	}
+
+	if errors.Is(err, errIfMatch) || errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrInvalidPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if errors.Is(err, store.ErrBlobNotFound) || errors.Is(err, store.ErrBucketNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if errors.Is(err, store.ErrRestricted) || errors.Is(err, store.ErrMissingParent) || errors.Is(err, store.ErrBlobConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if errors.Is(err, store.ErrConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// containersHandler lists the live containers, only the ones with unsaved changes or a failed
+// last write with ?dirty=true
+func (m *Monitoring) containersHandler(c *gin.Context) {
+	if c.Query("dirty") == "true" {
+		c.JSON(http.StatusOK, m.containers.Dirty())
+		return
+	}
+
+	c.JSON(http.StatusOK, m.containers.List())
+}
+
+func (m *Monitoring) containerHandler(c *gin.Context) {
+	stats, ok := m.containers.Get(c.Param("uid"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "container not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// flushContainersHandler writes the pending changes of every live container
+func (m *Monitoring) flushContainersHandler(c *gin.Context) {
+	if err := m.containers.FlushAll(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 func (m *Monitoring) assetsHandler(c *gin.Context) {
 	path := c.Param("filepath")
 	data, mime, err := m.cacheFs.AssetFile(path)
@@ -399,9 +841,13 @@ func (m *Monitoring) StartServer(fn func(err error)) {
 
 	server := m.createServer()
 
+	m.wg.Add(1)
 	go m.handleServerErrors(fn, server)
 
 	m.err <- server.ListenAndServe()
+
+	// wait for in-flight requests to finish before the caller closes the store
+	m.wg.Wait()
 }
 
 func (m *Monitoring) createServer() *http.Server {
@@ -412,16 +858,18 @@ func (m *Monitoring) createServer() *http.Server {
 }
 
 func (m *Monitoring) handleServerErrors(fn func(err error), server *http.Server) {
-	m.wg.Add(1)
 	defer m.wg.Done()
 
-	for {
-		select {
-		case <-m.ctx.Done():
-			if err := server.Shutdown(m.ctx); err != nil {
-				fn(err)
-			}
-		case err := <-m.err:
+	select {
+	case <-m.ctx.Done():
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			fn(err)
+		}
+	case err := <-m.err:
+		if !errors.Is(err, http.ErrServerClosed) {
 			fn(err)
 		}
 	}
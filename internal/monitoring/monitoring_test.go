@@ -1,5 +1,27 @@
 package monitoring
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dyammarcano/persistent-container/internal/container"
+	"github.com/dyammarcano/persistent-container/internal/owner"
+	"github.com/dyammarcano/persistent-container/internal/store"
+	"github.com/dyammarcano/persistent-container/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
 //func TestNewUI(t *testing.T) {
 //	router := mux.NewRouter()
 
@@ -53,3 +75,310 @@ package monitoring
 //	expected := `OK`
 //	assert.Equal(t, expected, rr.Body.String(), "response body differs")
 //}
+
+type person struct {
+	Name string
+}
+
+// newTestMonitoring returns the monitoring of a new store and the token of a user without permissions
+func newTestMonitoring(t *testing.T) (*Monitoring, string) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db, err := store.NewStore(ctx, filepath.Join(t.TempDir(), "monitoring.db"))
+	assert.NoErrorf(t, err, "error creating store")
+
+	t.Cleanup(func() {
+		cancel()
+		assert.NoErrorf(t, db.Close(), "error closing store")
+	})
+
+	// the routes without the demo middlewares, they write every request to the store
+	m := NewMonitoring(ctx, db, 0)
+	m.router = gin.New()
+	m.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/authorization", nil)
+	req.SetBasicAuth("john", "secret")
+	req.Header.Set("email", "john@wick.com")
+
+	recorder := httptest.NewRecorder()
+	m.router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+
+	return m, body.Token
+}
+
+// serve sends a request with the token, headers holds pairs of header names and values
+func serve(m *Monitoring, method string, target string, token string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	m.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMonitoring_AdminContainers(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	c := container.NewContainer[person](person{Name: "John"}, "people", "john", m.db, container.WithMode(container.ModeExplicit))
+	assert.NoError(t, c.Save())
+
+	assert.Equal(t, http.StatusUnauthorized, serve(m, http.MethodGet, "/admin/containers", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(m, http.MethodGet, "/admin/containers", token, nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(m, http.MethodPost, "/admin/containers/flush", token, nil).Code)
+
+	admin, err := owner.NewToken("root", "secret", "root@wick.com", time.Now().Add(time.Hour).Unix())
+	assert.NoError(t, err)
+	admin.Owner.Permissions.Admin = true
+
+	adminToken, err := admin.Encode()
+	assert.NoError(t, err)
+
+	recorder := serve(m, http.MethodGet, "/admin/containers", adminToken, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var list []struct {
+		Bucket string `json:"bucket"`
+		Key    string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+	assert.Equal(t, "people", list[0].Bucket)
+	assert.Equal(t, "john", list[0].Key)
+
+	// nothing is dirty, the filtered list is still a list
+	recorder = serve(m, http.MethodGet, "/admin/containers?dirty=true", adminToken, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, "[]", recorder.Body.String())
+
+	assert.Equal(t, http.StatusOK, serve(m, http.MethodGet, "/admin/containers/"+c.GetUid(), adminToken, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, "/admin/containers/unknown", adminToken, nil).Code)
+	assert.Equal(t, http.StatusNoContent, serve(m, http.MethodPost, "/admin/containers/flush", adminToken, nil).Code)
+}
+
+func TestMonitoring_Blobs(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	recorder := serve(m, http.MethodPost, "/api/v1/blobs", token, bytes.NewReader(data), "Content-Type", "application/octet-stream")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var created struct {
+		ID   string `json:"id"`
+		Size int64  `json:"size"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	assert.Equal(t, int64(len(data)), created.Size)
+
+	recorder = serve(m, http.MethodGet, "/api/v1/blobs/"+created.ID, token, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, data, recorder.Body.Bytes())
+
+	recorder = serve(m, http.MethodGet, "/api/v1/blobs/"+created.ID, token, nil, "Range", "bytes=1000-1499")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "bytes 1000-1499/3000", recorder.Header().Get("Content-Range"))
+	assert.Equal(t, data[1000:1500], recorder.Body.Bytes())
+
+	recorder = serve(m, http.MethodGet, "/api/v1/blobs/"+created.ID, token, nil, "Range", "bytes=5000-")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+
+	// blobs aren't data keys
+	recorder = serve(m, http.MethodGet, "/api/v1/data", token, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, "[]", recorder.Body.String())
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, "/api/v1/data/"+created.ID, token, nil).Code)
+
+	assert.Equal(t, http.StatusNoContent, serve(m, http.MethodDelete, "/api/v1/blobs/"+created.ID, token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, "/api/v1/blobs/"+created.ID, token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodDelete, "/api/v1/blobs/"+created.ID, token, nil).Code)
+}
+
+// postData writes a json body through the api and returns its id
+func postData(t *testing.T, m *Monitoring, target string, token string, body string) string {
+	recorder := serve(m, http.MethodPost, target, token, strings.NewReader(body), "Content-Type", "application/json")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	return created.ID
+}
+
+func TestMonitoring_DataPages(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	var ids []string
+	for i := range 5 {
+		ids = append(ids, postData(t, m, "/api/v1/data", token, fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	slices.Sort(ids)
+
+	var listed []string
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		recorder := serve(m, http.MethodGet, "/api/v1/data?limit=2&cursor="+url.QueryEscape(cursor), token, nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		// a page is a list like the unpaged listing
+		var page []store.Key
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		for _, key := range page {
+			listed = append(listed, key.Key)
+		}
+
+		if cursor = recorder.Header().Get("X-Next-Cursor"); cursor == "" {
+			assert.Equal(t, 2, pages, "last page")
+			break
+		}
+		assert.Len(t, page, 2)
+	}
+	assert.Equal(t, ids, listed)
+
+	assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodGet, "/api/v1/data?limit=0", token, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodGet, "/api/v1/data?limit=many", token, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodGet, "/api/v1/data?cursor=not-a-cursor", token, nil).Code)
+}
+
+func TestMonitoring_BucketPaths(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	root := postData(t, m, "/api/v1/data", token, `{"n":0}`)
+	postData(t, m, "/api/v1/data?path=orders", token, `{"n":1}`)
+	nested := postData(t, m, "/api/v1/data?path=orders/2024", token, `{"n":2}`)
+	postData(t, m, "/api/v1/data?path=orders/2024", token, `{"n":3}`)
+
+	type listing struct {
+		Stats   store.BucketStats `json:"stats"`
+		Buckets []string          `json:"buckets"`
+	}
+
+	recorder := serve(m, http.MethodGet, "/api/v1/buckets?path=orders", token, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var orders listing
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &orders))
+	assert.Equal(t, []string{"2024"}, orders.Buckets)
+	assert.Equal(t, "orders", orders.Stats.Path)
+	assert.Equal(t, 1, orders.Stats.Keys)
+	assert.Equal(t, 1, orders.Stats.Buckets)
+	assert.Equal(t, 3, orders.Stats.TotalKeys)
+
+	recorder = serve(m, http.MethodGet, "/api/v1/buckets", token, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var top listing
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &top))
+	assert.Equal(t, []string{"orders"}, top.Buckets)
+	assert.Equal(t, 4, top.Stats.TotalKeys)
+
+	assert.Equal(t, http.StatusOK, serve(m, http.MethodGet, "/api/v1/data/"+nested+"?path=orders/2024", token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, "/api/v1/data/"+nested, token, nil).Code)
+
+	for _, path := range []string{"__revisions", "orders//2024", "orders/x:events:y"} {
+		assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodGet, "/api/v1/buckets?path="+url.QueryEscape(path), token, nil).Code, path)
+		assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodDelete, "/api/v1/buckets?path="+url.QueryEscape(path), token, nil).Code, path)
+	}
+
+	// the bucket of the token can't be deleted, only the ones nested in it
+	assert.Equal(t, http.StatusBadRequest, serve(m, http.MethodDelete, "/api/v1/buckets", token, nil).Code)
+	assert.Equal(t, http.StatusNoContent, serve(m, http.MethodDelete, "/api/v1/buckets?path=orders", token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, "/api/v1/buckets?path=orders", token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodDelete, "/api/v1/buckets?path=orders", token, nil).Code)
+
+	assert.Equal(t, http.StatusOK, serve(m, http.MethodGet, "/api/v1/data/"+root, token, nil).Code)
+}
+
+func TestMonitoring_DataRevisions(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	recorder := serve(m, http.MethodPost, "/api/v1/data", token, strings.NewReader(`{"n":1}`), "Content-Type", "application/json")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	created := recorder.Header().Get("ETag")
+	assert.NotEmpty(t, created)
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	target := "/api/v1/data/" + body.ID
+
+	recorder = serve(m, http.MethodGet, target, token, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, created, recorder.Header().Get("ETag"))
+
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		headers := []string{"Content-Type", "application/json"}
+		if ifMatch != "" {
+			headers = append(headers, "If-Match", ifMatch)
+		}
+		return serve(m, http.MethodPut, target, token, strings.NewReader(`{"n":2}`), headers...)
+	}
+
+	assert.Equal(t, http.StatusPreconditionRequired, put("").Code)
+
+	recorder = put(created)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	updated := recorder.Header().Get("ETag")
+	assert.NotEqual(t, created, updated)
+
+	assert.Equal(t, http.StatusPreconditionFailed, put(created).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, put("W/"+updated).Code)
+	assert.Equal(t, http.StatusBadRequest, put("no-quotes").Code)
+
+	recorder = put("*")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	updated = recorder.Header().Get("ETag")
+
+	// * requires the key to exist
+	unknown := "/api/v1/data/" + store.GenerateKey()
+	assert.Equal(t, http.StatusPreconditionFailed, serve(m, http.MethodPut, unknown, token, strings.NewReader(`{"n":3}`), "If-Match", "*").Code)
+
+	assert.Equal(t, http.StatusPreconditionFailed, serve(m, http.MethodDelete, target, token, nil, "If-Match", created).Code)
+	assert.Equal(t, http.StatusNoContent, serve(m, http.MethodDelete, target, token, nil, "If-Match", updated).Code)
+	assert.Equal(t, http.StatusNotFound, serve(m, http.MethodGet, target, token, nil).Code)
+}
+
+func TestMonitoring_DataValidation(t *testing.T) {
+	m, token := newTestMonitoring(t)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, serve(m, http.MethodPost, "/api/v1/data", token, strings.NewReader(""), "Content-Type", "application/json").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, serve(m, http.MethodPost, "/api/v1/data", token, strings.NewReader(`{"n":`), "Content-Type", "application/json").Code)
+
+	// the validators registered for the bucket of the token run on the api writes
+	decoded := &owner.Token{}
+	assert.NoError(t, decoded.Decode(token))
+
+	m.db.RegisterValidator(decoded.GetBucket()+store.PathSeparator+"people", func(string, []byte) error {
+		return validation.NewError("name", "is required")
+	})
+
+	recorder := serve(m, http.MethodPost, "/api/v1/data?path=people", token, strings.NewReader(`{"n":1}`), "Content-Type", "application/json")
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+	var failed struct {
+		Fields []validation.FieldError `json:"fields"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &failed))
+	assert.Equal(t, []validation.FieldError{{Field: "name", Message: "is required"}}, failed.Fields)
+
+	postData(t, m, "/api/v1/data", token, `{"n":1}`)
+}
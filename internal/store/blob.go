@@ -0,0 +1,464 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"time"
+)
+
+const (
+	// blobsBucket keeps the manifests of the blobs, in a nested bucket per data bucket keyed by
+	// the blob key. Blobs have their own keys, a data key with the same name is another value
+	blobsBucket = "__blobs"
+
+	// chunksBucket keeps the chunks of the blobs, in a nested bucket per data bucket, keyed by
+	// the length prefixed blob key, the blob id and the big endian chunk index
+	chunksBucket = "__chunks"
+
+	// DefaultChunkSize is the chunk size of blobs written without one
+	DefaultChunkSize = 512 << 10
+)
+
+var (
+	// ErrBlobNotFound is returned when a bucket has no blob under a key
+	ErrBlobNotFound = errors.New("blob not found")
+
+	// ErrBlobConflict is returned by BlobWriter.Close when chunks of the blob were deleted while it
+	// was written, by DeleteBucket or DeleteBlob
+	ErrBlobConflict = errors.New("blob chunks deleted while writing")
+
+	// ErrChecksum is returned when a blob chunk doesn't match the checksum of the manifest
+	ErrChecksum = errors.New("chunk checksum mismatch")
+)
+
+type (
+	// BlobOptions sets how a blob is written
+	BlobOptions struct {
+		ChunkSize   int
+		ContentType string
+	}
+
+	// Manifest describes a blob, it is stored under the blob key while its content is split in
+	// chunks of ChunkSize bytes, Checksums holds the hex sha256 of each chunk
+	Manifest struct {
+		ID          string    `json:"id"`
+		Size        int64     `json:"size"`
+		ChunkSize   int       `json:"chunk_size"`
+		Checksums   []string  `json:"checksums"`
+		ContentType string    `json:"content_type,omitempty"`
+		ModTime     time.Time `json:"mod_time"`
+		Revision    uint64    `json:"revision"`
+	}
+
+	// BlobWriter streams a blob to the store, chunks are written as they fill up and the blob
+	// replaces the previous blob of the key on Close, readers never see a partial blob
+	BlobWriter struct {
+		p          *Store
+		bucketName string
+		key        string
+		manifest   Manifest
+		buf        []byte
+		chunks     int
+		err        error
+		closed     bool
+	}
+
+	// Blob reads a blob chunk by chunk, every chunk is checked against its checksum
+	Blob struct {
+		p          *Store
+		bucketName string
+		key        string
+		manifest   Manifest
+		offset     int64
+		index      int
+		chunk      []byte
+	}
+)
+
+// CreateBlob returns a writer that replaces the blob of bucketName/key on Close, the bucket is
+// created when missing
+func (p *Store) CreateBlob(bucketName string, key string, opts BlobOptions) *BlobWriter {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	_, err := splitPath(bucketName)
+
+	return &BlobWriter{
+		p:          p,
+		bucketName: bucketName,
+		key:        key,
+		manifest: Manifest{
+			ID:          uuid.NewString(),
+			ChunkSize:   opts.ChunkSize,
+			ContentType: opts.ContentType,
+		},
+		buf: make([]byte, 0, opts.ChunkSize),
+		err: err,
+	}
+}
+
+// PutBlob streams r into a blob stored under bucketName/key and returns its manifest
+func (p *Store) PutBlob(bucketName string, key string, r io.Reader, opts BlobOptions) (*Manifest, error) {
+	w := p.CreateBlob(bucketName, key, opts)
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Abort()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Manifest(), nil
+}
+
+// OpenBlob returns a reader over the blob stored under bucketName/key
+func (p *Store) OpenBlob(bucketName string, key string) (*Blob, error) {
+	var manifest Manifest
+	err := p.View(func(tx *bolt.Tx) error {
+		var err error
+		manifest, err = readManifest(tx, bucketName, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blob{p: p, bucketName: bucketName, key: key, manifest: manifest, index: -1}, nil
+}
+
+// DeleteBlob deletes the blob stored under bucketName/key with its chunks
+func (p *Store) DeleteBlob(bucketName string, key string) error {
+	return p.Update(func(tx *bolt.Tx) error {
+		manifest, err := readManifest(tx, bucketName, key)
+		if err != nil {
+			return err
+		}
+
+		if err = deleteChunks(tx, bucketName, key, manifest.ID); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(blobsBucket)).Bucket([]byte(bucketName)).Delete([]byte(key))
+	})
+}
+
+// readManifest returns the manifest stored under a key
+func readManifest(tx *bolt.Tx, bucketName string, key string) (Manifest, error) {
+	var manifest Manifest
+
+	var value []byte
+	if blobs := tx.Bucket([]byte(blobsBucket)); blobs != nil {
+		if bucket := blobs.Bucket([]byte(bucketName)); bucket != nil {
+			value = bucket.Get([]byte(key))
+		}
+	}
+
+	if value == nil {
+		return manifest, fmt.Errorf("%w: %s in bucket %s", ErrBlobNotFound, key, bucketName)
+	}
+
+	err := json.Unmarshal(value, &manifest)
+	return manifest, err
+}
+
+// keyPrefix returns the length prefixed key, it starts the chunk keys of a blob and
+// the reverse index entries of a parent
+func keyPrefix(key string) []byte {
+	prefix := binary.AppendUvarint(nil, uint64(len(key)))
+	return append(prefix, key...)
+}
+
+// chunkKey returns the key of a chunk of a blob
+func chunkKey(key string, id string, index int) []byte {
+	k := append(keyPrefix(key), id...)
+	return binary.BigEndian.AppendUint64(k, uint64(index))
+}
+
+// deleteChunks deletes the chunks of the blob with the id written under the key, the chunks
+// of the writers still streaming a blob to the key are left alone
+func deleteChunks(tx *bolt.Tx, bucketName string, key string, id string) error {
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return nil
+	}
+
+	bucket := chunks.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+
+	prefix := append(keyPrefix(key), id...)
+
+	var stale [][]byte
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		stale = append(stale, bytes.Clone(k))
+	}
+
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write buffers p and writes every chunk that fills up
+func (w *BlobWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if w.closed {
+		return 0, errors.New("blob writer closed")
+	}
+
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), w.manifest.ChunkSize-len(w.buf))
+		w.buf = append(w.buf, b[:n]...)
+		b = b[n:]
+		written += n
+
+		if len(w.buf) == w.manifest.ChunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffered chunk in its own transaction
+func (w *BlobWriter) flush() error {
+	sum := sha256.Sum256(w.buf)
+	k := chunkKey(w.key, w.manifest.ID, w.chunks)
+
+	err := w.p.Update(func(tx *bolt.Tx) error {
+		chunks, err := tx.CreateBucketIfNotExists([]byte(chunksBucket))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := chunks.CreateBucketIfNotExists([]byte(w.bucketName))
+		if err != nil {
+			return err
+		}
+
+		w.p.metrics.AddWriteBytes(int64(len(w.buf)))
+
+		return bucket.Put(k, w.buf)
+	})
+	if err != nil {
+		w.err = err
+		w.Abort()
+		return err
+	}
+
+	w.manifest.Checksums = append(w.manifest.Checksums, hex.EncodeToString(sum[:]))
+	w.manifest.Size += int64(len(w.buf))
+	w.chunks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last chunk and the manifest, the chunks of the blob the key held before
+// are deleted in the same transaction as the manifest is written. ErrBlobConflict is returned
+// when chunks of the blob are missing, the key keeps its previous blob then
+func (w *BlobWriter) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	w.closed = true
+	w.manifest.ModTime = time.Now().UTC()
+
+	err := w.p.Update(func(tx *bolt.Tx) error {
+		chunks := tx.Bucket([]byte(chunksBucket))
+		if chunks != nil {
+			chunks = chunks.Bucket([]byte(w.bucketName))
+		}
+
+		for i := range w.chunks {
+			if chunks == nil || chunks.Get(chunkKey(w.key, w.manifest.ID, i)) == nil {
+				return fmt.Errorf("%w: chunk %d of blob %s/%s", ErrBlobConflict, i, w.bucketName, w.key)
+			}
+		}
+
+		if _, err := createBucket(tx, w.bucketName); err != nil {
+			return err
+		}
+
+		previous, err := readManifest(tx, w.bucketName, w.key)
+		if err == nil {
+			err = deleteChunks(tx, w.bucketName, w.key, previous.ID)
+		}
+		if err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return err
+		}
+
+		if w.manifest.Revision, err = nextRevision(tx); err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(w.manifest)
+		if err != nil {
+			return err
+		}
+
+		blobs, err := tx.CreateBucketIfNotExists([]byte(blobsBucket))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := blobs.CreateBucketIfNotExists([]byte(w.bucketName))
+		if err != nil {
+			return err
+		}
+
+		w.p.metrics.AddWriteBytes(int64(len(value)))
+
+		return bucket.Put([]byte(w.key), value)
+	})
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+// Abort deletes the chunks written so far, the key keeps its previous value
+func (w *BlobWriter) Abort() {
+	w.closed = true
+
+	if w.chunks == 0 {
+		return
+	}
+
+	_ = w.p.Update(func(tx *bolt.Tx) error {
+		chunks := tx.Bucket([]byte(chunksBucket))
+		if chunks == nil {
+			return nil
+		}
+
+		bucket := chunks.Bucket([]byte(w.bucketName))
+		if bucket == nil {
+			return nil
+		}
+
+		for i := 0; i < w.chunks; i++ {
+			if err := bucket.Delete(chunkKey(w.key, w.manifest.ID, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Manifest returns the manifest of the blob, complete once Close returns
+func (w *BlobWriter) Manifest() *Manifest {
+	manifest := w.manifest
+	return &manifest
+}
+
+// Manifest returns the manifest of the blob
+func (b *Blob) Manifest() Manifest {
+	return b.manifest
+}
+
+// Size returns the size of the blob in bytes
+func (b *Blob) Size() int64 {
+	return b.manifest.Size
+}
+
+// Read reads from the current offset, loading and checking the chunks it crosses
+func (b *Blob) Read(p []byte) (int, error) {
+	if b.offset >= b.manifest.Size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && b.offset < b.manifest.Size {
+		index := int(b.offset / int64(b.manifest.ChunkSize))
+		if err := b.load(index); err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], b.chunk[b.offset%int64(b.manifest.ChunkSize):])
+		n += copied
+		b.offset += int64(copied)
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the next Read
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.manifest.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	b.offset = offset
+	return offset, nil
+}
+
+// load reads a chunk and checks it against its checksum
+func (b *Blob) load(index int) error {
+	if index == b.index {
+		return nil
+	}
+
+	if index >= len(b.manifest.Checksums) {
+		return fmt.Errorf("chunk %d of blob %s/%s: %w", index, b.bucketName, b.key, io.ErrUnexpectedEOF)
+	}
+
+	var chunk []byte
+	err := b.p.View(func(tx *bolt.Tx) error {
+		if chunks := tx.Bucket([]byte(chunksBucket)); chunks != nil {
+			if bucket := chunks.Bucket([]byte(b.bucketName)); bucket != nil {
+				chunk = append([]byte(nil), bucket.Get(chunkKey(b.key, b.manifest.ID, index))...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(chunk) == 0 {
+		return fmt.Errorf("chunk %d of blob %s/%s is missing, the blob was replaced or deleted", index, b.bucketName, b.key)
+	}
+
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != b.manifest.Checksums[index] {
+		return fmt.Errorf("chunk %d of blob %s/%s: %w", index, b.bucketName, b.key, ErrChecksum)
+	}
+
+	b.index = index
+	b.chunk = chunk
+	return nil
+}
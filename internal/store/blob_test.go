@@ -0,0 +1,123 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Blob(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "blob.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	data := make([]byte, 10_000)
+	_, _ = rand.Read(data)
+
+	manifest, err := per.PutBlob("files", "movie", bytes.NewReader(data), BlobOptions{ChunkSize: 1024, ContentType: "video/mp4"})
+	assert.NoErrorf(t, err, "error putting blob")
+	assert.Equal(t, int64(len(data)), manifest.Size)
+	assert.Len(t, manifest.Checksums, 10)
+
+	blob, err := per.OpenBlob("files", "movie")
+	assert.NoErrorf(t, err, "error opening blob")
+	assert.Equal(t, "video/mp4", blob.Manifest().ContentType)
+
+	read, err := io.ReadAll(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	_, err = blob.Seek(-1500, io.SeekEnd)
+	assert.NoError(t, err)
+	tail := make([]byte, 1000)
+	_, err = io.ReadFull(blob, tail)
+	assert.NoError(t, err)
+	assert.Equal(t, data[8500:9500], tail)
+
+	// replacing the blob drops the chunks of the previous one
+	_, err = per.PutBlob("files", "movie", bytes.NewReader(data[:100]), BlobOptions{ChunkSize: 1024})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countChunks(t, per, "files"))
+
+	// a corrupted chunk is detected
+	assert.NoError(t, per.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(chunksBucket)).Bucket([]byte("files"))
+		k, v := bucket.Cursor().First()
+		corrupted := append([]byte(nil), v...)
+		corrupted[0] ^= 0xff
+		return bucket.Put(k, corrupted)
+	}))
+
+	blob, err = per.OpenBlob("files", "movie")
+	assert.NoError(t, err)
+	_, err = io.ReadAll(blob)
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	// blobs and values have their own keys
+	assert.NoError(t, per.Put("files", "movie", []byte("value")))
+	assert.NoError(t, per.DeleteKey("files", "movie"))
+	assert.Equal(t, 1, countChunks(t, per, "files"))
+
+	count, err := per.CountKeys("files")
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	assert.NoError(t, per.DeleteBlob("files", "movie"))
+	assert.Zero(t, countChunks(t, per, "files"))
+
+	_, err = per.OpenBlob("files", "movie")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.ErrorIs(t, per.DeleteBlob("files", "movie"), ErrBlobNotFound)
+}
+
+func TestStore_BlobConcurrentWriters(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "blob.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	first, second := bytes.Repeat([]byte("a"), 3000), bytes.Repeat([]byte("b"), 2000)
+
+	w1 := per.CreateBlob("files", "movie", BlobOptions{ChunkSize: 1024})
+	w2 := per.CreateBlob("files", "movie", BlobOptions{ChunkSize: 1024})
+
+	_, err = w1.Write(first)
+	assert.NoError(t, err)
+	_, err = w2.Write(second)
+	assert.NoError(t, err)
+
+	// closing a writer leaves the chunks of the other one alone
+	assert.NoError(t, w2.Close())
+	assert.NoError(t, w1.Close())
+
+	blob, err := per.OpenBlob("files", "movie")
+	assert.NoError(t, err)
+	read, err := io.ReadAll(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, first, read)
+	assert.Equal(t, 3, countChunks(t, per, "files"))
+
+	// a writer whose chunks were deleted by DeleteBucket doesn't replace the blob
+	w3 := per.CreateBlob("files", "movie", BlobOptions{ChunkSize: 1024})
+	_, err = w3.Write(second)
+	assert.NoError(t, err)
+
+	assert.NoError(t, per.DeleteBucket("files"))
+	assert.ErrorIs(t, w3.Close(), ErrBlobConflict)
+
+	_, err = per.OpenBlob("files", "movie")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func countChunks(t *testing.T, per *Store, bucketName string) int {
+	count := 0
+	assert.NoError(t, per.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(chunksBucket)).Bucket([]byte(bucketName)).Stats().KeyN
+		return nil
+	}))
+	return count
+}
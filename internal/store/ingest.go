@@ -0,0 +1,237 @@
+package store
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultIngestTxSize is the number of entries committed per transaction by an ingest without a TxSize
+const DefaultIngestTxSize = 1000
+
+const (
+	// AllOrNothing writes every entry in a single transaction, the first entry that fails aborts
+	// the ingest and nothing is written. The entries are held in memory until the commit
+	AllOrNothing IngestMode = iota
+	// BestEffort commits the entries TxSize at a time, the entries that fail are reported and skipped
+	BestEffort
+)
+
+type (
+	// IngestMode is how an ingest deals with the entries that fail
+	IngestMode int
+
+	// EntrySeq yields the entries to ingest in order, an error yielded in place of an entry is
+	// reported as a failed item. It has the shape of iter.Seq2[Entry, error]
+	EntrySeq func(yield func(Entry, error) bool)
+
+	// IngestOptions sets how an ingest is written. TxSize is the number of entries committed per
+	// transaction with BestEffort, AllOrNothing always writes a single transaction and only uses it
+	// to report progress. Progress is called after every transaction with BestEffort and every TxSize
+	// entries with AllOrNothing, it must not write to the store
+	IngestOptions struct {
+		Mode     IngestMode
+		TxSize   int
+		Progress func(IngestProgress)
+	}
+
+	// IngestProgress counts the entries of an ingest so far, Written only counts committed entries
+	IngestProgress struct {
+		Processed int
+		Written   int
+		Failed    int
+	}
+
+	// IngestReport is the outcome of an ingest, Errors holds an error per failed entry in order
+	IngestReport struct {
+		Processed int
+		Written   int
+		Errors    []*ItemError
+	}
+
+	// ItemError is the error of an entry of an ingest, Index is its position in the input
+	ItemError struct {
+		Index  int
+		Bucket string
+		Key    string
+		Err    error
+	}
+
+	// ingest is the state of an ingest in progress, reported is the number of entries processed
+	// at the last progress
+	ingest struct {
+		p        *Store
+		opts     IngestOptions
+		report   IngestReport
+		batch    []Entry
+		indexes  []int
+		reported int
+	}
+)
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d, key %s in bucket %s: %v", e.Index, e.Key, e.Bucket, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Ingest writes the entries as IngestSeq does
+func (p *Store) Ingest(entries []Entry, opts IngestOptions) (IngestReport, error) {
+	return p.IngestSeq(func(yield func(Entry, error) bool) {
+		for _, entry := range entries {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}, opts)
+}
+
+// IngestSeq writes the entries of seq like PutMany, many of them per transaction. With AllOrNothing
+// the *ItemError of the entry that aborted the ingest is returned, with BestEffort the failed entries
+// are only in the report and an error is returned when a transaction can't be committed at all
+func (p *Store) IngestSeq(seq EntrySeq, opts IngestOptions) (IngestReport, error) {
+	if opts.TxSize <= 0 {
+		opts.TxSize = DefaultIngestTxSize
+	}
+
+	in := &ingest{p: p, opts: opts}
+	if opts.Mode == AllOrNothing {
+		return in.allOrNothing(seq)
+	}
+	return in.bestEffort(seq)
+}
+
+// allOrNothing collects and validates every entry, then writes them in a single transaction
+func (in *ingest) allOrNothing(seq EntrySeq) (IngestReport, error) {
+	seq(func(entry Entry, err error) bool {
+		in.report.Processed++
+		return in.add(entry, err)
+	})
+
+	if len(in.report.Errors) > 0 {
+		return in.abort()
+	}
+
+	revs := make([]uint64, len(in.batch))
+	err := in.p.Update(func(tx *bolt.Tx) error {
+		for i, entry := range in.batch {
+			var err error
+			if revs[i], err = in.p.write(tx, entry); err != nil {
+				in.fail(in.indexes[i], entry, err)
+				return err
+			}
+
+			if (i+1)%in.opts.TxSize == 0 && i+1 < len(in.batch) {
+				in.progress(IngestProgress{Processed: i + 1})
+			}
+		}
+		return nil
+	})
+	if len(in.report.Errors) > 0 {
+		return in.abort()
+	}
+	if err != nil {
+		return in.report, err
+	}
+
+	in.report.Written = len(in.batch)
+	in.p.publishPuts(in.batch, revs)
+	in.progress(IngestProgress{Processed: in.report.Processed, Written: in.report.Written})
+
+	return in.report, nil
+}
+
+// bestEffort commits the entries as soon as a transaction worth of them is collected
+func (in *ingest) bestEffort(seq EntrySeq) (IngestReport, error) {
+	var err error
+	seq(func(entry Entry, itemErr error) bool {
+		in.report.Processed++
+		in.add(entry, itemErr)
+
+		if len(in.batch) == in.opts.TxSize {
+			err = in.commit()
+		}
+		return err == nil
+	})
+
+	// the last entries may all have failed, their progress is still reported
+	if err == nil && in.report.Processed > in.reported {
+		err = in.commit()
+	}
+	return in.report, err
+}
+
+// add validates an entry and adds it to the batch, it reports whether the entry is valid
+func (in *ingest) add(entry Entry, err error) bool {
+	if err == nil {
+		err = in.p.validate(entry.Bucket, entry.Key, entry.Value)
+	}
+
+	if err != nil {
+		in.fail(in.report.Processed-1, entry, err)
+		return false
+	}
+
+	in.batch = append(in.batch, entry)
+	in.indexes = append(in.indexes, in.report.Processed-1)
+	return true
+}
+
+// commit writes the batch in a single transaction, every entry is checked before it is written
+// so the entries that would fail are reported and skipped without aborting the others
+func (in *ingest) commit() error {
+	var (
+		written []Entry
+		revs    []uint64
+		failed  []*ItemError
+	)
+
+	err := in.p.Update(func(tx *bolt.Tx) error {
+		for i, entry := range in.batch {
+			if err := in.p.check(tx, entry); err != nil {
+				failed = append(failed, &ItemError{Index: in.indexes[i], Bucket: entry.Bucket, Key: entry.Key, Err: err})
+				continue
+			}
+
+			rev, err := in.p.put(tx, entry.Bucket, entry.Key, entry.Value, entry.TTL)
+			if err != nil {
+				return err
+			}
+
+			written = append(written, entry)
+			revs = append(revs, rev)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	in.report.Errors = append(in.report.Errors, failed...)
+	in.report.Written += len(written)
+	in.p.publishPuts(written, revs)
+
+	in.batch, in.indexes = in.batch[:0], in.indexes[:0]
+	in.reported = in.report.Processed
+	in.progress(IngestProgress{Processed: in.report.Processed, Written: in.report.Written, Failed: len(in.report.Errors)})
+	return nil
+}
+
+// fail records the error of an entry
+func (in *ingest) fail(index int, entry Entry, err error) {
+	in.report.Errors = append(in.report.Errors, &ItemError{Index: index, Bucket: entry.Bucket, Key: entry.Key, Err: err})
+}
+
+// abort returns the report of an ingest where nothing was written and the error that aborted it
+func (in *ingest) abort() (IngestReport, error) {
+	in.report.Written = 0
+	in.progress(IngestProgress{Processed: in.report.Processed, Failed: len(in.report.Errors)})
+	return in.report, in.report.Errors[0]
+}
+
+func (in *ingest) progress(progress IngestProgress) {
+	if in.opts.Progress != nil {
+		in.opts.Progress(progress)
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// movies yields n entries of the movies bucket, the entry at broken is an error
+func movies(n int, broken int) EntrySeq {
+	return func(yield func(Entry, error) bool) {
+		for i := 0; i < n; i++ {
+			var err error
+			if i == broken {
+				err = errors.New("unreadable record")
+			}
+
+			if !yield(Entry{Bucket: "movies", Key: fmt.Sprintf("movie-%03d", i), Value: []byte(fmt.Sprintf("value %d", i))}, err) {
+				return
+			}
+		}
+	}
+}
+
+func TestStore_IngestBestEffort(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "ingest.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	errRejected := errors.New("rejected")
+	per.RegisterValidator("movies", func(key string, value []byte) error {
+		if key == "movie-007" {
+			return errRejected
+		}
+		return nil
+	})
+
+	// movie-012 fails inside the transaction, the others of its transaction are still written
+	rev, err := per.PutIfRevision("movies", "movie-012", []byte("taken"), 0)
+	assert.NoError(t, err)
+
+	seq := movies(25, 3)
+	conflicting := func(yield func(Entry, error) bool) {
+		seq(func(entry Entry, err error) bool {
+			if entry.Key == "movie-012" {
+				entry.IfRevision, entry.Revision = true, rev+100
+			}
+			return yield(entry, err)
+		})
+	}
+
+	writes := per.GetMetrics().Iops.TotalWrites
+
+	var progress []IngestProgress
+	report, err := per.IngestSeq(conflicting, IngestOptions{Mode: BestEffort, TxSize: 10, Progress: func(p IngestProgress) {
+		progress = append(progress, p)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 25, report.Processed)
+	assert.Equal(t, 22, report.Written)
+
+	assert.Equal(t, writes+3, per.GetMetrics().Iops.TotalWrites, "a transaction run again")
+
+	assert.Len(t, report.Errors, 3)
+	assert.Equal(t, 3, report.Errors[0].Index)
+	assert.ErrorIs(t, report.Errors[1], errRejected)
+	assert.Equal(t, "movie-012", report.Errors[2].Key)
+	assert.ErrorIs(t, report.Errors[2], ErrConflict)
+
+	assert.Equal(t, []IngestProgress{
+		{Processed: 12, Written: 10, Failed: 2},
+		{Processed: 22, Written: 19, Failed: 3},
+		{Processed: 25, Written: 22, Failed: 3},
+	}, progress)
+
+	count, err := per.CountKeys("movies")
+	assert.NoError(t, err)
+	assert.Equal(t, 23, count)
+
+	value, err := per.Get("movies", "movie-012")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("taken"), value)
+}
+
+func TestStore_IngestBestEffortRefused(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "ingest.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.Put("movies", "marvel", []byte("a key")))
+	assert.NoError(t, per.Put("movies/dc", "batman", []byte("a nested bucket")))
+
+	// the entries bolt refuses are reported, the valid ones of the transaction are still written
+	entries := []Entry{
+		{Bucket: "movies", Key: "movie-000", Value: []byte("value 0")},
+		{Bucket: "movies", Key: "", Value: []byte("no key")},
+		{Bucket: "movies", Key: "movie-001", Value: []byte("value 1")},
+		{Bucket: "movies", Key: strings.Repeat("x", bolt.MaxKeySize), Value: []byte("long key")},
+		{Bucket: "movies/marvel", Key: "ironman", Value: []byte("under a key")},
+		{Bucket: "movies", Key: "dc", Value: []byte("over a bucket")},
+		{Bucket: "movies", Key: "movie-002", Value: []byte("value 2")},
+	}
+
+	report, err := per.Ingest(entries, IngestOptions{Mode: BestEffort})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Written)
+
+	assert.Len(t, report.Errors, 4)
+	assert.ErrorIs(t, report.Errors[0], bolt.ErrKeyRequired)
+	assert.ErrorIs(t, report.Errors[1], bolt.ErrKeyTooLarge)
+	assert.ErrorIs(t, report.Errors[2], bolt.ErrIncompatibleValue)
+	assert.ErrorIs(t, report.Errors[3], bolt.ErrIncompatibleValue)
+	assert.Equal(t, []int{1, 3, 4, 5}, []int{report.Errors[0].Index, report.Errors[1].Index, report.Errors[2].Index, report.Errors[3].Index})
+
+	count, err := per.CountKeys("movies")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
+
+func TestStore_IngestAllOrNothing(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "ingest.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	report, err := per.IngestSeq(movies(25, 20), IngestOptions{Mode: AllOrNothing})
+
+	var itemErr *ItemError
+	assert.ErrorAs(t, err, &itemErr)
+	assert.Equal(t, 20, itemErr.Index)
+	assert.Zero(t, report.Written)
+
+	count, err := per.CountKeys("movies")
+	assert.NoError(t, err)
+	assert.Zero(t, count, "aborted ingest written")
+
+	// a failure inside the transaction rolls it back as well
+	assert.NoError(t, per.Put("movies", "movie-005", []byte("taken")))
+
+	entries := make([]Entry, 0, 10)
+	movies(10, -1)(func(entry Entry, err error) bool {
+		if entry.Key == "movie-005" {
+			entry.IfRevision = true
+		}
+		entries = append(entries, entry)
+		return true
+	})
+
+	_, err = per.Ingest(entries, IngestOptions{Mode: AllOrNothing})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	count, err = per.CountKeys("movies")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	entries[5].IfRevision = false
+
+	var progress []IngestProgress
+	report, err = per.Ingest(entries, IngestOptions{Mode: AllOrNothing, TxSize: 4, Progress: func(p IngestProgress) {
+		progress = append(progress, p)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, IngestReport{Processed: 10, Written: 10}, report)
+	assert.Equal(t, []IngestProgress{{Processed: 4}, {Processed: 8}, {Processed: 10, Written: 10}}, progress)
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+)
+
+// logSnapshotKey keeps the snapshot of a log, it isn't 8 bytes long so ReadLog never yields it
+const logSnapshotKey = "__snapshot"
+
+// Append adds the values at the end of a log bucket in a single transaction and returns the
+// sequence of the last one, values are keyed by their big endian sequence number. The last
+// sequence of the bucket must be expected, a *ConflictError is returned otherwise
+func (p *Store) Append(bucketName string, expected uint64, values ...[]byte) (uint64, error) {
+	keys := make([][]byte, len(values))
+	for i, value := range values {
+		keys[i] = binary.BigEndian.AppendUint64(nil, expected+uint64(i)+1)
+		if err := p.validate(bucketName, string(keys[i]), value); err != nil {
+			return 0, err
+		}
+	}
+
+	var rev uint64
+	err := p.Update(func(tx *bolt.Tx) error {
+		bucket, err := createLog(tx, bucketName)
+		if err != nil {
+			return err
+		}
+
+		if actual := bucket.Sequence(); actual != expected {
+			return &ConflictError{Bucket: bucketName, Expected: expected, Actual: actual}
+		}
+
+		for i, value := range values {
+			p.metrics.AddWriteBytes(int64(len(value)))
+
+			if err = bucket.Put(keys[i], value); err != nil {
+				return err
+			}
+		}
+
+		if err = bucket.SetSequence(expected + uint64(len(values))); err != nil {
+			return err
+		}
+
+		rev, err = nextRevision(tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	events := make([]Event, 0, len(values))
+	for i, value := range values {
+		events = append(events, Event{Bucket: bucketName, Key: string(keys[i]), Op: OpPut, Value: append([]byte(nil), value...), Revision: rev})
+	}
+	p.publish(events...)
+
+	return expected + uint64(len(values)), nil
+}
+
+// ReadLog calls fn in order for every value of a log bucket with a sequence greater than after,
+// value is only valid during the call
+func (p *Store) ReadLog(bucketName string, after uint64, fn func(seq uint64, value []byte) error) error {
+	return p.View(func(tx *bolt.Tx) error {
+		bucket := logOf(tx, bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(binary.BigEndian.AppendUint64(nil, after+1)); k != nil; k, v = cursor.Next() {
+			if len(k) != 8 {
+				continue
+			}
+
+			if err := fn(binary.BigEndian.Uint64(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PutLogSnapshot writes the snapshot of a log bucket, it is kept in the log bucket so it is
+// deleted with the log and never mixed with the values of a data bucket
+func (p *Store) PutLogSnapshot(bucketName string, value []byte) error {
+	return p.Update(func(tx *bolt.Tx) error {
+		bucket, err := createLog(tx, bucketName)
+		if err != nil {
+			return err
+		}
+
+		p.metrics.AddWriteBytes(int64(len(value)))
+
+		return bucket.Put([]byte(logSnapshotKey), value)
+	})
+}
+
+// GetLogSnapshot returns a copy of the snapshot of a log bucket, nil if it has none
+func (p *Store) GetLogSnapshot(bucketName string) ([]byte, error) {
+	var value []byte
+	err := p.View(func(tx *bolt.Tx) error {
+		if bucket := logOf(tx, bucketName); bucket != nil {
+			value = bytes.Clone(bucket.Get([]byte(logSnapshotKey)))
+		}
+		return nil
+	})
+	return value, err
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"sync"
+)
+
+const (
+	// OpPut is sent when a key is written
+	OpPut Op = iota
+	// OpDelete is sent when a key is deleted, Key is empty when the whole bucket is deleted
+	OpDelete
+)
+
+type (
+	// Op is the operation of an Event
+	Op int
+
+	// Event describes a change committed to the store through Put, Ingest, DeleteKey or DeleteBucket,
+	// Revision is the revision the change was committed at
+	Event struct {
+		Bucket   string
+		Key      string
+		Op       Op
+		Value    []byte
+		Revision uint64
+	}
+
+	subscription struct {
+		bucketName string
+		key        string
+		fn         func(Event)
+		mu         sync.Mutex
+		queue      []Event
+		signal     chan struct{}
+		done       chan struct{}
+	}
+
+	// notifier indexes the subscriptions by bucket, key and id, the key of the subscriptions
+	// to a whole bucket is empty
+	notifier struct {
+		mu     sync.RWMutex
+		nextID int
+		subs   map[string]map[string]map[int]*subscription
+	}
+)
+
+func newNotifier() *notifier {
+	return &notifier{
+		mu:   sync.RWMutex{},
+		subs: make(map[string]map[string]map[int]*subscription),
+	}
+}
+
+// Subscribe calls fn for every change committed to key of bucketName, or to any key of the bucket
+// when key is empty. Events are delivered one at a time from a goroutine of the subscription, so fn
+// may use the store. Writers publish their events after their commit, the events of concurrent
+// writes may arrive out of commit order, Event.Revision tells which change is the latest.
+// The returned function cancels the subscription
+func (p *Store) Subscribe(bucketName string, key string, fn func(Event)) func() {
+	sub := &subscription{
+		bucketName: bucketName,
+		key:        key,
+		fn:         fn,
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	p.notifier.mu.Lock()
+	id := p.notifier.nextID
+	p.notifier.nextID++
+	p.notifier.add(id, sub)
+	p.notifier.mu.Unlock()
+
+	go sub.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.notifier.mu.Lock()
+			defer p.notifier.mu.Unlock()
+
+			if p.notifier.remove(id, sub) {
+				close(sub.done)
+			}
+		})
+	}
+}
+
+// unsubscribeAll stops every subscription, used when the store is closed
+func (p *Store) unsubscribeAll() {
+	p.notifier.mu.Lock()
+	defer p.notifier.mu.Unlock()
+
+	for _, keys := range p.notifier.subs {
+		for _, subs := range keys {
+			for _, sub := range subs {
+				close(sub.done)
+			}
+		}
+	}
+	clear(p.notifier.subs)
+}
+
+// publish queues the events for the matching subscriptions, it never blocks the writer
+func (p *Store) publish(events ...Event) {
+	p.notifier.mu.RLock()
+	defer p.notifier.mu.RUnlock()
+
+	for _, event := range events {
+		keys := p.notifier.subs[event.Bucket]
+
+		// a bucket event goes to every subscription of the bucket
+		if event.Key == "" {
+			for _, subs := range keys {
+				for _, sub := range subs {
+					sub.push(event)
+				}
+			}
+			continue
+		}
+
+		for _, sub := range keys[event.Key] {
+			sub.push(event)
+		}
+		for _, sub := range keys[""] {
+			sub.push(event)
+		}
+	}
+}
+
+// add indexes a subscription, the caller must hold the lock
+func (n *notifier) add(id int, sub *subscription) {
+	keys, ok := n.subs[sub.bucketName]
+	if !ok {
+		keys = make(map[string]map[int]*subscription)
+		n.subs[sub.bucketName] = keys
+	}
+
+	if keys[sub.key] == nil {
+		keys[sub.key] = make(map[int]*subscription)
+	}
+	keys[sub.key][id] = sub
+}
+
+// remove drops a subscription from the index, it reports whether it was still there,
+// the caller must hold the lock
+func (n *notifier) remove(id int, sub *subscription) bool {
+	keys := n.subs[sub.bucketName]
+	if _, ok := keys[sub.key][id]; !ok {
+		return false
+	}
+
+	delete(keys[sub.key], id)
+	if len(keys[sub.key]) == 0 {
+		delete(keys, sub.key)
+	}
+	if len(keys) == 0 {
+		delete(n.subs, sub.bucketName)
+	}
+	return true
+}
+
+func (s *subscription) push(event Event) {
+	s.mu.Lock()
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.signal:
+			s.mu.Lock()
+			events := s.queue
+			s.queue = nil
+			s.mu.Unlock()
+
+			for _, event := range events {
+				s.fn(event)
+			}
+		}
+	}
+}
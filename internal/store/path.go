@@ -0,0 +1,251 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+const (
+	// PathSeparator separates the names of nested buckets in a bucket path such as tenant/orders/2024
+	PathSeparator = "/"
+
+	// internalPrefix starts the names of the metadata buckets
+	internalPrefix = "__"
+
+	// logInfix is in the names of the logs of the event-sourced containers, bucket:events:key
+	logInfix = ":events:"
+)
+
+var (
+	// ErrInvalidPath is returned when a bucket path has an empty name or a name the store keeps for itself
+	ErrInvalidPath = errors.New("invalid bucket path")
+
+	// ErrBucketNotFound is returned when a bucket path doesn't exist
+	ErrBucketNotFound = bolt.ErrBucketNotFound
+)
+
+type (
+	// BucketStats describes the bucket at a path, Keys and Buckets count its own keys and child
+	// buckets, TotalKeys also counts the keys of every nested bucket and Bytes is the space in use
+	BucketStats struct {
+		Path      string `json:"path"`
+		Keys      int    `json:"keys"`
+		Buckets   int    `json:"buckets"`
+		TotalKeys int    `json:"total_keys"`
+		Bytes     int    `json:"bytes"`
+	}
+)
+
+// ListBuckets returns the names of the child buckets of path in order, the top level buckets
+// for an empty path, the buckets the store keeps for itself are hidden
+func (p *Store) ListBuckets(path string) ([]string, error) {
+	names := make([]string, 0)
+	err := p.View(func(tx *bolt.Tx) error {
+		visit := func(name []byte, _ *bolt.Bucket) error {
+			if !isInternal(string(name)) {
+				names = append(names, string(name))
+			}
+			return nil
+		}
+
+		if path == "" {
+			return tx.ForEach(visit)
+		}
+
+		bucket := bucketOf(tx, path)
+		if bucket == nil {
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, path)
+		}
+		return childBuckets(bucket, visit)
+	})
+	return names, err
+}
+
+// BucketStats returns the stats of the bucket at path
+func (p *Store) BucketStats(path string) (BucketStats, error) {
+	stats := BucketStats{Path: path}
+	err := p.View(func(tx *bolt.Tx) error {
+		bucket := bucketOf(tx, path)
+		if bucket == nil {
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, path)
+		}
+
+		for _, nested := range descendants(bucket, path) {
+			keys := countKeys(tx, nested)
+			stats.TotalKeys += keys
+
+			if nested == path {
+				stats.Keys = keys
+			}
+		}
+
+		err := childBuckets(bucket, func(name []byte, _ *bolt.Bucket) error {
+			if !isInternal(string(name)) {
+				stats.Buckets++
+			}
+			return nil
+		})
+
+		bs := bucket.Stats()
+		stats.Bytes = bs.BranchInuse + bs.LeafInuse
+		return err
+	})
+	return stats, err
+}
+
+// ValidatePath returns an ErrInvalidPath error if a bucket path has an empty name or a name the
+// store keeps for itself
+func ValidatePath(path string) error {
+	_, err := splitPath(path)
+	return err
+}
+
+// splitPath returns the names of the buckets of a path, none of them can be empty or be kept by
+// the store for itself, see isInternal
+func splitPath(path string) ([][]byte, error) {
+	names, err := splitLogPath(path)
+	if err == nil && strings.Contains(string(names[len(names)-1]), logInfix) {
+		return nil, fmt.Errorf("%w: %q holds the name of an event log", ErrInvalidPath, path)
+	}
+	return names, err
+}
+
+// splitLogPath returns the names of the buckets of the path of a log, like splitPath but the
+// last name may be the one of an event log
+func splitLogPath(path string) ([][]byte, error) {
+	names := strings.Split(path, PathSeparator)
+
+	parts := make([][]byte, len(names))
+	for i, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+
+		if strings.HasPrefix(name, internalPrefix) || (i < len(names)-1 && strings.Contains(name, logInfix)) {
+			return nil, fmt.Errorf("%w: %q holds a name kept by the store", ErrInvalidPath, path)
+		}
+		parts[i] = []byte(name)
+	}
+	return parts, nil
+}
+
+// bucketOf returns the bucket at path, nil if it or one of its parents doesn't exist
+func bucketOf(tx *bolt.Tx, path string) *bolt.Bucket {
+	names, err := splitPath(path)
+	if err != nil {
+		return nil
+	}
+	return bucketAt(tx, names)
+}
+
+// logOf returns the bucket of the log at path, nil if it doesn't exist
+func logOf(tx *bolt.Tx, path string) *bolt.Bucket {
+	names, err := splitLogPath(path)
+	if err != nil {
+		return nil
+	}
+	return bucketAt(tx, names)
+}
+
+// bucketAt returns the bucket nested at names, nil if it or one of its parents doesn't exist
+func bucketAt(tx *bolt.Tx, names [][]byte) *bolt.Bucket {
+	bucket := tx.Bucket(names[0])
+	for _, name := range names[1:] {
+		if bucket == nil {
+			return nil
+		}
+		bucket = bucket.Bucket(name)
+	}
+	return bucket
+}
+
+// createBucket returns the bucket at path, it and its parents are created if they don't exist
+func createBucket(tx *bolt.Tx, path string) (*bolt.Bucket, error) {
+	names, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return createAt(tx, names)
+}
+
+// createLog returns the bucket of the log at path, it and its parents are created if they don't exist
+func createLog(tx *bolt.Tx, path string) (*bolt.Bucket, error) {
+	names, err := splitLogPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return createAt(tx, names)
+}
+
+// createAt returns the bucket nested at names, it and its parents are created if they don't exist
+func createAt(tx *bolt.Tx, names [][]byte) (*bolt.Bucket, error) {
+	bucket, err := tx.CreateBucketIfNotExists(names[0])
+	for _, name := range names[1:] {
+		if err != nil {
+			return nil, err
+		}
+		bucket, err = bucket.CreateBucketIfNotExists(name)
+	}
+	return bucket, err
+}
+
+// removeBucket deletes the bucket at path with every bucket nested in it
+func removeBucket(tx *bolt.Tx, path string) error {
+	i := strings.LastIndex(path, PathSeparator)
+	if i < 0 {
+		return tx.DeleteBucket([]byte(path))
+	}
+
+	parent := bucketOf(tx, path[:i])
+	if parent == nil {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, path)
+	}
+	return parent.DeleteBucket([]byte(path[i+1:]))
+}
+
+// descendants returns the path followed by the paths of every bucket nested in it
+func descendants(bucket *bolt.Bucket, path string) []string {
+	paths := []string{path}
+	_ = childBuckets(bucket, func(name []byte, child *bolt.Bucket) error {
+		paths = append(paths, descendants(child, path+PathSeparator+string(name))...)
+		return nil
+	})
+	return paths
+}
+
+// childBuckets calls fn for every bucket nested directly in the bucket
+func childBuckets(bucket *bolt.Bucket, fn func(name []byte, child *bolt.Bucket) error) error {
+	return bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(k, bucket.Bucket(k))
+	})
+}
+
+// countKeys returns the number of keys of the bucket at path, expired and nested buckets aside
+func countKeys(tx *bolt.Tx, path string) int {
+	bucket := bucketOf(tx, path)
+	if bucket == nil {
+		return 0
+	}
+
+	count := 0
+	expiries, now := expiries(tx, path), time.Now()
+	_ = bucket.ForEach(func(k, v []byte) error {
+		if v != nil && !isExpired(expiries, k, now) {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// isInternal reports whether a bucket is kept by the store for itself, the metadata buckets and
+// the logs of the event-sourced containers
+func isInternal(name string) bool {
+	return strings.HasPrefix(name, internalPrefix) || strings.Contains(name, logInfix)
+}
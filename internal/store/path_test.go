@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_NestedBuckets(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "nested.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.Put("acme/orders/2024", "order-1", []byte("10 anvils")))
+	assert.NoError(t, per.Put("acme/orders/2024", "order-2", []byte("3 rockets")))
+	assert.NoError(t, per.PutWithTTL("acme/orders/2023", "order-0", []byte("1 magnet"), time.Hour))
+	assert.NoError(t, per.Put("acme/orders", "latest", []byte("order-2")))
+	assert.NoError(t, per.Put("acme/customers", "coyote", []byte("Wile E.")))
+	assert.NoError(t, per.Put("globex", "hank", []byte("Hank Scorpio")))
+
+	assert.ErrorIs(t, per.Put("acme//orders", "order-3", []byte("")), ErrInvalidPath)
+
+	value, err := per.Get("acme/orders/2024", "order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("10 anvils"), value)
+
+	rev, err := per.GetRevision("acme/orders/2024", "order-1")
+	assert.NoError(t, err)
+	assert.NotZero(t, rev)
+
+	roots, err := per.ListBuckets("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, roots, "internal buckets listed")
+
+	children, err := per.ListBuckets("acme/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2023", "2024"}, children)
+
+	_, err = per.ListBuckets("initech")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+
+	// the child buckets are not keys of their parent
+	keys, err := per.GetBucketKeys("acme/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []Key{{Key: "latest"}}, keys)
+
+	stats, err := per.BucketStats("acme/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme/orders", stats.Path)
+	assert.Equal(t, 1, stats.Keys)
+	assert.Equal(t, 2, stats.Buckets)
+	assert.Equal(t, 4, stats.TotalKeys)
+	assert.Positive(t, stats.Bytes)
+
+	events := make(chan Event, 10)
+	per.Subscribe("acme/orders/2024", "", func(event Event) {
+		events <- event
+	})
+
+	assert.NoError(t, per.DeleteBucket("acme/orders"))
+	assert.Equal(t, Event{Bucket: "acme/orders/2024", Op: OpDelete, Revision: rev + 6}, <-events)
+
+	children, err = per.ListBuckets("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"customers"}, children)
+
+	// the metadata of the nested buckets went with them
+	rev, err = per.GetRevision("acme/orders/2024", "order-1")
+	assert.NoError(t, err)
+	assert.Zero(t, rev)
+
+	ttl, err := per.GetTTL("acme/orders/2023", "order-0")
+	assert.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	assert.ErrorIs(t, per.DeleteBucket("acme/orders"), ErrBucketNotFound)
+}
+
+func TestStore_ReservedNames(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "reserved.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.Put("movies", "Rogers", []byte("Avengers, Assemble!")))
+
+	for _, path := range []string{"__revisions", "movies/__chunks", "movies:events:Rogers", "acme/movies:events:Rogers", "acme:events:x/movies"} {
+		assert.ErrorIs(t, ValidatePath(path), ErrInvalidPath, path)
+		assert.ErrorIs(t, per.Put(path, "Rogers", []byte("overwritten")), ErrInvalidPath, path)
+		assert.ErrorIs(t, per.DeleteBucket(path), ErrInvalidPath, path)
+
+		keys, err := per.GetBucketKeys(path)
+		assert.NoError(t, err)
+		assert.Empty(t, keys, path)
+	}
+
+	assert.NoError(t, ValidatePath("acme/movies"))
+
+	// the store still keeps the revisions
+	rev, err := per.GetRevision("movies", "Rogers")
+	assert.NoError(t, err)
+	assert.NotZero(t, rev)
+
+	// event logs are only written and read through Append and ReadLog
+	seq, err := per.Append("acme/movies:events:Rogers", 0, []byte("assembled"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+
+	var values []string
+	assert.NoError(t, per.ReadLog("acme/movies:events:Rogers", 0, func(_ uint64, value []byte) error {
+		values = append(values, string(value))
+		return nil
+	}))
+	assert.Equal(t, []string{"assembled"}, values)
+
+	// the snapshot of a log is kept in the log without being one of its values
+	assert.NoError(t, per.PutLogSnapshot("acme/movies:events:Rogers", []byte("state")))
+
+	snapshot, err := per.GetLogSnapshot("acme/movies:events:Rogers")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("state"), snapshot)
+
+	values = values[:0]
+	assert.NoError(t, per.ReadLog("acme/movies:events:Rogers", 0, func(_ uint64, value []byte) error {
+		values = append(values, string(value))
+		return nil
+	}))
+	assert.Equal(t, []string{"assembled"}, values)
+
+	_, err = per.Append("__revisions", 0, []byte("broken"))
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	_, err = per.Append("acme:events:x/movies", 0, []byte("broken"))
+	assert.ErrorIs(t, err, ErrInvalidPath)
+}
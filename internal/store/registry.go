@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+	"sync"
+)
+
+type (
+	// Tracked is implemented by objects that keep state in memory on top of the store,
+	// like containers, so it can be persisted before the store is closed
+	Tracked interface {
+		Flush() error
+		Close() error
+	}
+
+	registry struct {
+		mu    sync.RWMutex
+		items map[string]Tracked
+	}
+)
+
+func newRegistry() *registry {
+	return &registry{
+		mu:    sync.RWMutex{},
+		items: make(map[string]Tracked),
+	}
+}
+
+// list returns the tracked objects without holding the lock while they are used
+func (r *registry) list() []Tracked {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	items := make([]Tracked, 0, len(r.items))
+	for _, item := range r.items {
+		items = append(items, item)
+	}
+	return items
+}
+
+// Track registers an object to be flushed and closed together with the store
+func (p *Store) Track(uid string, t Tracked) {
+	p.registry.mu.Lock()
+	defer p.registry.mu.Unlock()
+
+	p.registry.items[uid] = t
+}
+
+// Untrack removes an object from the store registry
+func (p *Store) Untrack(uid string) {
+	p.registry.mu.Lock()
+	defer p.registry.mu.Unlock()
+
+	delete(p.registry.items, uid)
+}
+
+// FlushAll persists the pending state of every tracked object
+func (p *Store) FlushAll() error {
+	var errs []error
+	for _, item := range p.registry.list() {
+		if err := item.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ListTracked returns every tracked object
+func (p *Store) ListTracked() []Tracked {
+	return p.registry.list()
+}
+
+// CloseAll closes every tracked object, each one flushes its pending state and untracks itself,
+// the store stays open
+func (p *Store) CloseAll() error {
+	var errs []error
+	for _, item := range p.registry.list() {
+		if err := item.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,427 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+const (
+	// relationsBucket keeps the references of every relation, in a nested bucket per relation holding
+	// the parent of each child in parentsBucket and the reverse index of the children in childrenBucket,
+	// keyed by the length prefixed parent key followed by the child key
+	relationsBucket = "__relations"
+
+	parentsBucket  = "parents"
+	childrenBucket = "children"
+)
+
+const (
+	// Restrict fails the delete of a key that still has children, expired children don't count
+	Restrict OnDelete = iota
+	// Cascade deletes the children with their parent
+	Cascade
+	// SetNull clears the reference of the children with Relation.Unset
+	SetNull
+)
+
+var (
+	// ErrRestricted is returned when a delete is blocked by a Restrict relation
+	ErrRestricted = errors.New("key is still referenced")
+
+	// ErrMissingParent is returned when a value references a key that doesn't exist
+	ErrMissingParent = errors.New("referenced key doesn't exist")
+)
+
+type (
+	// OnDelete is what happens to the children of a key when it is deleted
+	OnDelete int
+
+	// Relation declares that the values of the Child bucket reference keys of the Parent bucket,
+	// Ref returns the parent key a value references, "" for none, and Unset returns the value
+	// without its reference, it is only required by SetNull
+	Relation struct {
+		Name     string
+		Parent   string
+		Child    string
+		OnDelete OnDelete
+		Ref      func(key string, value []byte) (string, error)
+		Unset    func(key string, value []byte) ([]byte, error)
+	}
+
+	// Child is a key that references another one through a relation
+	Child struct {
+		Relation string `json:"relation"`
+		Bucket   string `json:"bucket"`
+		Key      string `json:"key"`
+	}
+)
+
+func (o OnDelete) String() string {
+	switch o {
+	case Restrict:
+		return "restrict"
+	case Cascade:
+		return "cascade"
+	case SetNull:
+		return "set-null"
+	default:
+		return fmt.Sprintf("OnDelete(%d)", int(o))
+	}
+}
+
+// RegisterRelation declares a relation, or replaces the one with the same name, and indexes the
+// values already stored in the child bucket. From then on every value written to the child bucket
+// must reference an existing parent and deleting a parent applies rel.OnDelete to its children
+// in the same transaction
+func (p *Store) RegisterRelation(rel Relation) error {
+	switch {
+	case rel.Name == "" || rel.Parent == "" || rel.Child == "":
+		return errors.New("relation needs a name, a parent and a child bucket")
+	case rel.Ref == nil:
+		return fmt.Errorf("relation %s has no Ref", rel.Name)
+	case rel.OnDelete == SetNull && rel.Unset == nil:
+		return fmt.Errorf("relation %s is set-null and has no Unset", rel.Name)
+	case rel.OnDelete < Restrict || rel.OnDelete > SetNull:
+		return fmt.Errorf("relation %s: unknown %s", rel.Name, rel.OnDelete)
+	}
+
+	return p.Update(func(tx *bolt.Tx) error {
+		if err := dropIndex(tx, rel.Name); err != nil {
+			return err
+		}
+
+		if bucket := bucketOf(tx, rel.Child); bucket != nil {
+			err := bucket.ForEach(func(k, v []byte) error {
+				// nested buckets are not values
+				if v == nil {
+					return nil
+				}
+
+				ref, err := rel.Ref(string(k), v)
+				if err != nil {
+					return fmt.Errorf("relation %s: key %s: %w", rel.Name, k, err)
+				}
+				return addRef(tx, rel.Name, string(k), ref)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		p.relationsMu.Lock()
+		defer p.relationsMu.Unlock()
+
+		p.relations[rel.Name] = rel
+		return nil
+	})
+}
+
+// Children returns the keys that reference the key through the relations whose parent is bucketName,
+// ordered by relation and key
+func (p *Store) Children(bucketName string, key string) ([]Child, error) {
+	var list []Child
+	err := p.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, rel := range p.relationsTo(bucketName) {
+			for _, child := range children(tx, rel.Name, key) {
+				if !isExpired(expiries(tx, rel.Child), []byte(child), now) {
+					list = append(list, Child{Relation: rel.Name, Bucket: rel.Child, Key: child})
+				}
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// relationsTo returns the relations whose parent is bucketName ordered by name
+func (p *Store) relationsTo(bucketName string) []Relation {
+	return p.relationsWhere(func(rel Relation) bool { return rel.Parent == bucketName })
+}
+
+// relationsFrom returns the relations whose child is bucketName ordered by name
+func (p *Store) relationsFrom(bucketName string) []Relation {
+	return p.relationsWhere(func(rel Relation) bool { return rel.Child == bucketName })
+}
+
+func (p *Store) relationsWhere(match func(Relation) bool) []Relation {
+	p.relationsMu.RLock()
+	defer p.relationsMu.RUnlock()
+
+	var list []Relation
+	for _, rel := range p.relations {
+		if match(rel) {
+			list = append(list, rel)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// checkParents returns an ErrMissingParent error if a value written to a child bucket references
+// a parent that doesn't exist
+func (p *Store) checkParents(tx *bolt.Tx, bucketName string, key string, value []byte) error {
+	for _, rel := range p.relationsFrom(bucketName) {
+		ref, err := rel.Ref(key, value)
+		if err != nil {
+			return fmt.Errorf("relation %s: %w", rel.Name, err)
+		}
+
+		if ref != "" {
+			// the reverse index keeps the parent and the child key in a single bolt key
+			if len(keyPrefix(ref))+len(key) > bolt.MaxKeySize {
+				return fmt.Errorf("relation %s, reference of %s: %w", rel.Name, key, bolt.ErrKeyTooLarge)
+			}
+
+			parent := bucketOf(tx, rel.Parent)
+			if parent == nil || parent.Get([]byte(ref)) == nil || isExpired(expiries(tx, rel.Parent), []byte(ref), time.Now()) {
+				return fmt.Errorf("%w: %s in bucket %s, referenced by %s through relation %s", ErrMissingParent, ref, rel.Parent, key, rel.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// link indexes the reference of a value written to a child bucket, the value must have passed checkParents
+func (p *Store) link(tx *bolt.Tx, bucketName string, key string, value []byte) error {
+	for _, rel := range p.relationsFrom(bucketName) {
+		ref, err := rel.Ref(key, value)
+		if err != nil {
+			return fmt.Errorf("relation %s: %w", rel.Name, err)
+		}
+
+		if err = removeRef(tx, rel.Name, key); err != nil {
+			return err
+		}
+
+		if err = addRef(tx, rel.Name, key, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlink removes the references of a key deleted from a child bucket
+func (p *Store) unlink(tx *bolt.Tx, bucketName string, key string) error {
+	for _, rel := range p.relationsFrom(bucketName) {
+		if err := removeRef(tx, rel.Name, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDelete returns an ErrRestricted error if deleting the key would delete or update a key that
+// is still referenced through a Restrict relation, seen guards against reference cycles
+func (p *Store) checkDelete(tx *bolt.Tx, bucketName string, key string, seen map[string]bool) error {
+	for _, rel := range p.relationsTo(bucketName) {
+		if err := p.checkRelation(tx, rel, key, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRelation runs checkDelete for the children of the key in a relation, the expired children
+// are deleted whatever the relation
+func (p *Store) checkRelation(tx *bolt.Tx, rel Relation, key string, seen map[string]bool) error {
+	now := time.Now()
+	for _, child := range children(tx, rel.Name, key) {
+		expired := isExpired(expiries(tx, rel.Child), []byte(child), now)
+
+		if rel.OnDelete == Restrict && !expired {
+			return fmt.Errorf("%w: %s in bucket %s has children in bucket %s through relation %s", ErrRestricted, key, rel.Parent, rel.Child, rel.Name)
+		}
+
+		id := rel.Child + "\x00" + child
+		if (rel.OnDelete == Cascade || expired) && !seen[id] {
+			seen[id] = true
+			if err := p.checkDelete(tx, rel.Child, child, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// release applies the relation to the children of a deleted key and returns the events of the
+// children deleted or updated
+func (p *Store) release(tx *bolt.Tx, rel Relation, key string) ([]Event, error) {
+	var events []Event
+
+	now := time.Now()
+	for _, child := range children(tx, rel.Name, key) {
+		// released already through another relation
+		if parent, ok := parentOf(tx, rel.Name, child); !ok || parent != key {
+			continue
+		}
+
+		if rel.OnDelete == SetNull && !isExpired(expiries(tx, rel.Child), []byte(child), now) {
+			event, err := p.setNull(tx, rel, child)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+			continue
+		}
+
+		deleted, err := p.removeKey(tx, rel.Child, child)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, deleted...)
+	}
+	return events, nil
+}
+
+// setNull writes the child without its reference, the child keeps its deadline
+func (p *Store) setNull(tx *bolt.Tx, rel Relation, key string) (Event, error) {
+	value, err := rel.Unset(key, bucketOf(tx, rel.Child).Get([]byte(key)))
+	if err != nil {
+		return Event{}, fmt.Errorf("relation %s: %w", rel.Name, err)
+	}
+
+	if err = p.validate(rel.Child, key, value); err != nil {
+		return Event{}, err
+	}
+
+	var ttl time.Duration
+	if deadline, ok := deadlineOf(expiries(tx, rel.Child), key); ok {
+		ttl = time.Until(deadline)
+	}
+
+	rev, err := p.put(tx, rel.Child, key, value, ttl)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Bucket: rel.Child, Key: key, Op: OpPut, Value: append([]byte(nil), value...), Revision: rev}, nil
+}
+
+// referenced returns the parent keys referenced through the relation
+func referenced(tx *bolt.Tx, name string) []string {
+	index := relationIndex(tx, name, childrenBucket)
+	if index == nil {
+		return nil
+	}
+
+	var keys []string
+	cursor := index.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		n, size := binary.Uvarint(k)
+		if size <= 0 || uint64(len(k)-size) < n {
+			continue
+		}
+
+		// the children of a parent are next to each other
+		if parent := string(k[size : size+int(n)]); len(keys) == 0 || keys[len(keys)-1] != parent {
+			keys = append(keys, parent)
+		}
+	}
+	return keys
+}
+
+// children returns the child keys that reference the key through the relation
+func children(tx *bolt.Tx, name string, key string) []string {
+	index := relationIndex(tx, name, childrenBucket)
+	if index == nil {
+		return nil
+	}
+
+	prefix := keyPrefix(key)
+
+	var keys []string
+	cursor := index.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		keys = append(keys, string(k[len(prefix):]))
+	}
+	return keys
+}
+
+// parentOf returns the parent key the child references through the relation
+func parentOf(tx *bolt.Tx, name string, key string) (string, bool) {
+	parents := relationIndex(tx, name, parentsBucket)
+	if parents == nil {
+		return "", false
+	}
+
+	parent := parents.Get([]byte(key))
+	return string(parent), parent != nil
+}
+
+// addRef records that the child references the parent, an empty parent records nothing
+func addRef(tx *bolt.Tx, name string, key string, parent string) error {
+	if parent == "" {
+		return nil
+	}
+
+	relations, err := tx.CreateBucketIfNotExists([]byte(relationsBucket))
+	if err != nil {
+		return err
+	}
+
+	index, err := relations.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+
+	parents, err := index.CreateBucketIfNotExists([]byte(parentsBucket))
+	if err != nil {
+		return err
+	}
+
+	if err = parents.Put([]byte(key), []byte(parent)); err != nil {
+		return err
+	}
+
+	reverse, err := index.CreateBucketIfNotExists([]byte(childrenBucket))
+	if err != nil {
+		return err
+	}
+	return reverse.Put(append(keyPrefix(parent), key...), nil)
+}
+
+// removeRef removes the reference of the child and its reverse index entry
+func removeRef(tx *bolt.Tx, name string, key string) error {
+	parent, ok := parentOf(tx, name, key)
+	if !ok {
+		return nil
+	}
+
+	if err := relationIndex(tx, name, childrenBucket).Delete(append(keyPrefix(parent), key...)); err != nil {
+		return err
+	}
+	return relationIndex(tx, name, parentsBucket).Delete([]byte(key))
+}
+
+// dropIndex removes every reference of the relation
+func dropIndex(tx *bolt.Tx, name string) error {
+	relations := tx.Bucket([]byte(relationsBucket))
+	if relations == nil {
+		return nil
+	}
+
+	if err := relations.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	return nil
+}
+
+// relationIndex returns a nested bucket of the index of the relation, nil if nothing was indexed
+func relationIndex(tx *bolt.Tx, name string, index string) *bolt.Bucket {
+	relations := tx.Bucket([]byte(relationsBucket))
+	if relations == nil {
+		return nil
+	}
+
+	bucket := relations.Bucket([]byte(name))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Bucket([]byte(index))
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// memberOf relates the members to the team their value names, none is no team
+func memberOf(name string, child string, onDelete OnDelete) Relation {
+	return Relation{
+		Name:     name,
+		Parent:   "teams",
+		Child:    child,
+		OnDelete: onDelete,
+		Ref: func(key string, value []byte) (string, error) {
+			if string(value) == "none" {
+				return "", nil
+			}
+			return string(value), nil
+		},
+		Unset: func(key string, value []byte) ([]byte, error) {
+			return []byte("none"), nil
+		},
+	}
+}
+
+func TestStore_Relations(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "relations.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.Put("teams", "avengers", []byte("Avengers")))
+	assert.NoError(t, per.Put("teams", "defenders", []byte("Defenders")))
+
+	// children written before the relation is declared are indexed
+	assert.NoError(t, per.Put("members", "Rogers", []byte("avengers")))
+
+	assert.NoError(t, per.RegisterRelation(memberOf("members", "members", Cascade)))
+	assert.NoError(t, per.RegisterRelation(memberOf("mentors", "mentors", SetNull)))
+	assert.NoError(t, per.RegisterRelation(memberOf("founders", "founders", Restrict)))
+
+	assert.NoError(t, per.Put("members", "Stark", []byte("avengers")))
+	assert.NoError(t, per.Put("members", "Murdock", []byte("defenders")))
+	assert.NoError(t, per.Put("mentors", "Fury", []byte("avengers")))
+	assert.NoError(t, per.Put("founders", "Cage", []byte("defenders")))
+
+	assert.ErrorIs(t, per.Put("members", "Parker", []byte("x-men")), ErrMissingParent)
+
+	children, err := per.Children("teams", "avengers")
+	assert.NoError(t, err)
+	assert.Equal(t, []Child{
+		{Relation: "members", Bucket: "members", Key: "Rogers"},
+		{Relation: "members", Bucket: "members", Key: "Stark"},
+		{Relation: "mentors", Bucket: "mentors", Key: "Fury"},
+	}, children)
+
+	// moving a child updates the reverse index
+	assert.NoError(t, per.Put("members", "Stark", []byte("defenders")))
+	children, err = per.Children("teams", "avengers")
+	assert.NoError(t, err)
+	assert.Len(t, children, 2)
+
+	events := make(chan Event, 10)
+	for _, bucketName := range []string{"teams", "members", "mentors"} {
+		per.Subscribe(bucketName, "", func(event Event) {
+			events <- event
+		})
+	}
+
+	assert.NoError(t, per.DeleteKey("teams", "avengers"))
+
+	received := make(map[string]Event)
+	for range 3 {
+		event := <-events
+		received[event.Bucket] = event
+	}
+	assert.Equal(t, OpDelete, received["teams"].Op)
+	assert.Equal(t, OpDelete, received["members"].Op, "member not deleted")
+	assert.Equal(t, "Rogers", received["members"].Key)
+	assert.Equal(t, OpPut, received["mentors"].Op, "mentor not updated")
+	assert.Equal(t, []byte("none"), received["mentors"].Value)
+
+	value, err := per.Get("members", "Rogers")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	value, err = per.Get("mentors", "Fury")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("none"), value)
+
+	// a restrict child blocks the delete, the transaction is rolled back
+	assert.ErrorIs(t, per.DeleteKey("teams", "defenders"), ErrRestricted)
+	assert.ErrorIs(t, per.DeleteBucket("teams"), ErrRestricted)
+
+	value, err = per.Get("members", "Murdock")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("defenders"), value)
+
+	assert.NoError(t, per.DeleteKey("founders", "Cage"))
+	assert.NoError(t, per.DeleteBucket("teams"))
+
+	keys, err := per.GetBucketKeys("members")
+	assert.NoError(t, err)
+	assert.Empty(t, keys, "members not deleted with the bucket")
+}
+
+func TestStore_RelationsExpiry(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "relations.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.RegisterRelation(memberOf("founders", "founders", Restrict)))
+
+	assert.NoError(t, per.PutWithTTL("teams", "avengers", []byte("Avengers"), 20*time.Millisecond))
+	assert.NoError(t, per.Put("founders", "Rogers", []byte("avengers")))
+
+	time.Sleep(30 * time.Millisecond)
+
+	// the expired parent can't be referenced anymore and is kept while referenced
+	assert.ErrorIs(t, per.Put("founders", "Stark", []byte("avengers")), ErrMissingParent)
+
+	swept, err := per.Sweep()
+	assert.NoError(t, err)
+	assert.Zero(t, swept)
+
+	assert.NoError(t, per.DeleteKey("founders", "Rogers"))
+
+	swept, err = per.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept)
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// revisionsBucket keeps the revision of every key, in a nested bucket per data bucket.
+// Revisions come from a single sequence so they increase across the whole store and
+// are never reused, not even after a key or its bucket is deleted
+const revisionsBucket = "__revisions"
+
+// ErrConflict is matched by every *ConflictError
+var ErrConflict = errors.New("revision conflict")
+
+type (
+	// ConflictError is returned when a conditional write finds the key at another revision,
+	// or when Append finds a log at another sequence, Key is empty then
+	ConflictError struct {
+		Bucket   string
+		Key      string
+		Expected uint64
+		Actual   uint64
+	}
+)
+
+func (e *ConflictError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("log %s is at sequence %d, expected %d", e.Bucket, e.Actual, e.Expected)
+	}
+	if e.Actual == 0 {
+		return fmt.Sprintf("key %s doesn't exist in bucket %s", e.Key, e.Bucket)
+	}
+	return fmt.Sprintf("key %s in bucket %s is at revision %d, expected %d", e.Key, e.Bucket, e.Actual, e.Expected)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// PutIfRevision writes the value only if the key is still at the expected revision and returns
+// the new revision, a *ConflictError is returned otherwise. Revision 0 expects a key that
+// doesn't exist or that was written before revisions were kept
+func (p *Store) PutIfRevision(bucketName string, key string, value []byte, expected uint64) (uint64, error) {
+	revs, err := p.PutMany([]Entry{{Bucket: bucketName, Key: key, Value: value, IfRevision: true, Revision: expected}})
+	if err != nil {
+		return 0, err
+	}
+	return revs[0], nil
+}
+
+// DeleteIfRevision deletes the key only if it is still at the expected revision,
+// a *ConflictError is returned otherwise
+func (p *Store) DeleteIfRevision(bucketName string, key string, expected uint64) error {
+	var events []Event
+	err := p.Update(func(tx *bolt.Tx) error {
+		if err := checkRevision(tx, bucketName, key, expected); err != nil {
+			return err
+		}
+
+		var err error
+		events, err = p.deleteKey(tx, bucketName, key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	p.publish(events...)
+	return nil
+}
+
+// GetRevision returns the revision of the key, 0 if it doesn't exist
+func (p *Store) GetRevision(bucketName string, key string) (uint64, error) {
+	var rev uint64
+	err := p.View(func(tx *bolt.Tx) error {
+		rev = revision(tx, bucketName, key)
+		return nil
+	})
+	return rev, err
+}
+
+// GetWithRevision returns the value of the key and its revision read in the same transaction
+func (p *Store) GetWithRevision(bucketName string, key string) ([]byte, uint64, error) {
+	var (
+		value []byte
+		rev   uint64
+	)
+	err := p.View(func(tx *bolt.Tx) error {
+		if value = bytes.Clone(lookup(tx, bucketName, key)); value != nil {
+			rev = revision(tx, bucketName, key)
+		}
+		return nil
+	})
+	return value, rev, err
+}
+
+// revision returns the revision of the key inside a transaction, 0 once the key has expired
+func revision(tx *bolt.Tx, bucketName string, key string) uint64 {
+	if isExpired(expiries(tx, bucketName), []byte(key), time.Now()) {
+		return 0
+	}
+
+	revisions := tx.Bucket([]byte(revisionsBucket))
+	if revisions == nil {
+		return 0
+	}
+
+	bucket := revisions.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return 0
+	}
+
+	if data := bucket.Get([]byte(key)); len(data) == 8 {
+		return binary.BigEndian.Uint64(data)
+	}
+	return 0
+}
+
+// checkRevision returns a *ConflictError if the key is not at the expected revision
+func checkRevision(tx *bolt.Tx, bucketName string, key string, expected uint64) error {
+	if actual := revision(tx, bucketName, key); actual != expected {
+		return &ConflictError{Bucket: bucketName, Key: key, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// nextRevision takes the next revision of the store
+func nextRevision(tx *bolt.Tx) (uint64, error) {
+	revisions, err := tx.CreateBucketIfNotExists([]byte(revisionsBucket))
+	if err != nil {
+		return 0, err
+	}
+	return revisions.NextSequence()
+}
+
+// setRevision assigns a new revision to the key and returns it
+func setRevision(tx *bolt.Tx, bucketName string, key string) (uint64, error) {
+	rev, err := nextRevision(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	bucket, err := tx.Bucket([]byte(revisionsBucket)).CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return 0, err
+	}
+
+	return rev, bucket.Put([]byte(key), binary.BigEndian.AppendUint64(nil, rev))
+}
+
+// deleteKey deletes the key once the relations allow it, see removeKey
+func (p *Store) deleteKey(tx *bolt.Tx, bucketName string, key string) ([]Event, error) {
+	if err := p.checkDelete(tx, bucketName, key, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return p.removeKey(tx, bucketName, key)
+}
+
+// removeKey deletes the key, its revision, its deadline and its references, then applies the relations
+// to its children. It returns the events of the delete and of the children deleted or updated
+func (p *Store) removeKey(tx *bolt.Tx, bucketName string, key string) ([]Event, error) {
+	if bucket := bucketOf(tx, bucketName); bucket != nil {
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := clearExpiry(tx, bucketName, key); err != nil {
+		return nil, err
+	}
+
+	if err := p.unlink(tx, bucketName, key); err != nil {
+		return nil, err
+	}
+
+	rev, err := nextRevision(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if revisions := tx.Bucket([]byte(revisionsBucket)).Bucket([]byte(bucketName)); revisions != nil {
+		if err = revisions.Delete([]byte(key)); err != nil {
+			return nil, err
+		}
+	}
+
+	events := []Event{{Bucket: bucketName, Key: key, Op: OpDelete, Revision: rev}}
+	for _, rel := range p.relationsTo(bucketName) {
+		released, err := p.release(tx, rel, key)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, released...)
+	}
+	return events, nil
+}
+
+// deleteBucket deletes the bucket at path with every bucket nested in it, and the revisions, deadlines,
+// references and blobs of their keys. The relations whose parent is one of them are applied to the
+// children in other buckets, the expiry index entries left behind are dropped by the sweeper. It returns
+// the events of the delete of each bucket and of the children
+func (p *Store) deleteBucket(tx *bolt.Tx, path string) ([]Event, error) {
+	if _, err := splitPath(path); err != nil {
+		return nil, err
+	}
+
+	bucket := bucketOf(tx, path)
+	if bucket == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, path)
+	}
+
+	paths := descendants(bucket, path)
+
+	deleted := make(map[string]bool, len(paths))
+	for _, name := range paths {
+		deleted[name] = true
+	}
+
+	var parents []Relation
+	for _, name := range paths {
+		for _, rel := range p.relationsTo(name) {
+			// the children are deleted with the buckets
+			if !deleted[rel.Child] {
+				parents = append(parents, rel)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, rel := range parents {
+		for _, key := range referenced(tx, rel.Name) {
+			if err := p.checkRelation(tx, rel, key, seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := removeBucket(tx, path); err != nil {
+		return nil, err
+	}
+
+	rev, err := nextRevision(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(paths))
+	for _, name := range paths {
+		for _, meta := range []string{expiresBucket, blobsBucket, chunksBucket, revisionsBucket} {
+			if parent := tx.Bucket([]byte(meta)); parent != nil {
+				if err = parent.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+					return nil, err
+				}
+			}
+		}
+
+		for _, rel := range p.relationsFrom(name) {
+			if err = dropIndex(tx, rel.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		events = append(events, Event{Bucket: name, Op: OpDelete, Revision: rev})
+	}
+
+	for _, rel := range parents {
+		for _, key := range referenced(tx, rel.Name) {
+			released, err := p.release(tx, rel, key)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, released...)
+		}
+	}
+	return events, nil
+}
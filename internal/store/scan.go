@@ -0,0 +1,173 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	// DefaultScanLimit is the page size of a scan without a limit
+	DefaultScanLimit = 100
+
+	cursorVersion = 1
+)
+
+// ErrInvalidCursor is returned when ScanOptions.After is not a token returned by Scan
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type (
+	// ScanOptions selects the keys of a scan, Start is the first key and End the key the scan stops
+	// at, both are optional. After is the Next token of the previous page, the scan resumes after its
+	// last key in the same direction
+	ScanOptions struct {
+		Prefix  string
+		Start   string
+		End     string
+		After   string
+		Limit   int
+		Reverse bool
+	}
+
+	// KeyValue is a key and its value, the value is a copy the caller owns
+	KeyValue struct {
+		Key   string `json:"key"`
+		Value []byte `json:"value"`
+	}
+
+	// Page holds the keys of a scan in order, Next is empty on the last page
+	Page struct {
+		Items []KeyValue `json:"items"`
+		Next  string     `json:"next,omitempty"`
+	}
+)
+
+// Scan reads a page of keys of a bucket in key order, or in reverse order, with a cursor so only the
+// page is loaded in memory. Expired keys are skipped, a bucket that doesn't exist has no keys
+func (p *Store) Scan(bucketName string, opts ScanOptions) (Page, error) {
+	page := Page{Items: []KeyValue{}}
+
+	var after []byte
+	if opts.After != "" {
+		var err error
+		if after, err = decodeCursor(opts.After); err != nil {
+			return page, err
+		}
+	}
+
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultScanLimit
+	}
+
+	err := p.View(func(tx *bolt.Tx) error {
+		bucket := bucketOf(tx, bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		expiries, now := expiries(tx, bucketName), time.Now()
+
+		cursor := bucket.Cursor()
+		k, v, next := seekForward(cursor, opts, after)
+		if opts.Reverse {
+			k, v, next = seekBackward(cursor, opts, after)
+		}
+
+		prefix := []byte(opts.Prefix)
+		for ; k != nil && bytes.HasPrefix(k, prefix) && inRange(k, opts); k, v = next() {
+			// nested buckets and expired keys are not values
+			if v == nil || isExpired(expiries, k, now) {
+				continue
+			}
+
+			// a key past the page proves there is another one
+			if len(page.Items) == opts.Limit {
+				page.Next = encodeCursor(page.Items[len(page.Items)-1].Key)
+				return nil
+			}
+
+			page.Items = append(page.Items, KeyValue{Key: string(k), Value: append([]byte(nil), v...)})
+		}
+		return nil
+	})
+	return page, err
+}
+
+// seekForward moves the cursor to the first key of an ascending scan
+func seekForward(cursor *bolt.Cursor, opts ScanOptions, after []byte) ([]byte, []byte, func() ([]byte, []byte)) {
+	from := []byte(opts.Start)
+	if bytes.Compare([]byte(opts.Prefix), from) > 0 {
+		from = []byte(opts.Prefix)
+	}
+
+	if after != nil && bytes.Compare(after, from) >= 0 {
+		k, v := cursor.Seek(after)
+		if bytes.Equal(k, after) {
+			k, v = cursor.Next()
+		}
+		return k, v, cursor.Next
+	}
+
+	k, v := cursor.Seek(from)
+	return k, v, cursor.Next
+}
+
+// seekBackward moves the cursor to the first key of a descending scan, the last one before End,
+// the end of the prefix or the token
+func seekBackward(cursor *bolt.Cursor, opts ScanOptions, after []byte) ([]byte, []byte, func() ([]byte, []byte)) {
+	var until []byte
+	for _, bound := range [][]byte{[]byte(opts.End), prefixEnd([]byte(opts.Prefix)), after} {
+		if len(bound) > 0 && (until == nil || bytes.Compare(bound, until) < 0) {
+			until = bound
+		}
+	}
+
+	if until == nil {
+		k, v := cursor.Last()
+		return k, v, cursor.Prev
+	}
+
+	if k, _ := cursor.Seek(until); k == nil {
+		k, v := cursor.Last()
+		return k, v, cursor.Prev
+	}
+
+	k, v := cursor.Prev()
+	return k, v, cursor.Prev
+}
+
+// inRange reports whether the key is between Start and End
+func inRange(k []byte, opts ScanOptions) bool {
+	if opts.Start != "" && bytes.Compare(k, []byte(opts.Start)) < 0 {
+		return false
+	}
+	return opts.End == "" || bytes.Compare(k, []byte(opts.End)) < 0
+}
+
+// prefixEnd returns the first key after every key with the prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// encodeCursor returns the token of a page that ends at key
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString(append([]byte{cursorVersion}, key...))
+}
+
+// decodeCursor returns the key a token resumes after
+func decodeCursor(token string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < 2 || data[0] != cursorVersion {
+		return nil, ErrInvalidCursor
+	}
+	return data[1:], nil
+}
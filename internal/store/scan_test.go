@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// scanKeys returns the keys of every page of a scan
+func scanKeys(t *testing.T, per *Store, opts ScanOptions) [][]string {
+	var pages [][]string
+	for {
+		page, err := per.Scan("movies", opts)
+		assert.NoError(t, err)
+
+		keys := make([]string, 0, len(page.Items))
+		for _, item := range page.Items {
+			keys = append(keys, item.Key)
+		}
+		pages = append(pages, keys)
+
+		if page.Next == "" {
+			return pages
+		}
+		opts.After = page.Next
+	}
+}
+
+func TestStore_Scan(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "scan.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	for _, key := range []string{"a1", "a2", "a3", "b1", "b2", "c1"} {
+		assert.NoError(t, per.Put("movies", key, []byte("value of "+key)))
+	}
+	assert.NoError(t, per.PutWithTTL("movies", "a4", []byte("expired"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	page, err := per.Scan("movies", ScanOptions{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a1", Value: []byte("value of a1")}, {Key: "a2", Value: []byte("value of a2")}}, page.Items)
+	assert.NotEmpty(t, page.Next)
+
+	assert.Equal(t, [][]string{{"a1", "a2"}, {"a3", "b1"}, {"b2", "c1"}}, scanKeys(t, per, ScanOptions{Limit: 2}))
+	assert.Equal(t, [][]string{{"c1", "b2", "b1", "a3"}, {"a2", "a1"}}, scanKeys(t, per, ScanOptions{Limit: 4, Reverse: true}))
+
+	assert.Equal(t, [][]string{{"a1", "a2", "a3"}}, scanKeys(t, per, ScanOptions{Prefix: "a"}))
+	assert.Equal(t, [][]string{{"a3", "a2"}, {"a1"}}, scanKeys(t, per, ScanOptions{Prefix: "a", Limit: 2, Reverse: true}))
+
+	assert.Equal(t, [][]string{{"a2", "a3", "b1"}}, scanKeys(t, per, ScanOptions{Start: "a2", End: "b2"}))
+	assert.Equal(t, [][]string{{"b1", "a3"}, {"a2"}}, scanKeys(t, per, ScanOptions{Start: "a2", End: "b2", Limit: 2, Reverse: true}))
+
+	assert.Equal(t, [][]string{{}}, scanKeys(t, per, ScanOptions{Prefix: "d"}))
+
+	_, err = per.Scan("movies", ScanOptions{After: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	page, err = per.Scan("unknown", ScanOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Items)
+}
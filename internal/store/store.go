@@ -1,8 +1,11 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dyammarcano/persistent-container/internal/algorithm/compression"
 	"github.com/dyammarcano/persistent-container/internal/metrics"
@@ -12,20 +15,45 @@ import (
 	"time"
 )
 
+// maxKeySize is the longest a bucket path and a key can add up to, the expiry index keeps both
+// in a single bolt key with the deadline and the length of the path
+const maxKeySize = bolt.MaxKeySize - 8 - binary.MaxVarintLen64
+
 type (
 	performAction func(tx *bolt.Tx) error
 
 	Store struct {
 		*bolt.DB
-		mu      sync.RWMutex
-		Ctx     context.Context
-		metrics *metrics.Metrics
+		Ctx      context.Context
+		metrics  *metrics.Metrics
+		registry *registry
+		notifier *notifier
+
+		stopSweeper context.CancelFunc
+		swept       chan struct{}
+
+		validatorsMu sync.RWMutex
+		validators   map[string][]ValueValidator
+
+		relationsMu sync.RWMutex
+		relations   map[string]Relation
 	}
 
 	Key struct {
 		Key string `json:"id"`
 	}
 
+	// Entry is a value to write with PutMany, with IfRevision set the entry is only
+	// written if the key is still at Revision, with a TTL the key expires like with PutWithTTL
+	Entry struct {
+		Bucket     string
+		Key        string
+		Value      []byte
+		IfRevision bool
+		Revision   uint64
+		TTL        time.Duration
+	}
+
 	WrapData struct {
 		Object    any
 		Timestamp int64
@@ -40,20 +68,26 @@ func NewStore(ctx context.Context, path string) (*Store, error) {
 	}
 
 	s := &Store{
-		DB:  db,
-		Ctx: ctx,
-		mu:  sync.RWMutex{},
+		DB:       db,
+		Ctx:      ctx,
+		registry: newRegistry(),
+		notifier: newNotifier(),
+
+		validators: make(map[string][]ValueValidator),
+		relations:  make(map[string]Relation),
+		swept:      make(chan struct{}),
 	}
 
 	s.metrics = metrics.NewMetrics(ctx, db)
 
+	sweepCtx, cancel := context.WithCancel(ctx)
+	s.stopSweeper = cancel
+	go s.sweeper(sweepCtx, sweepInterval)
+
 	return s, nil
 }
 
 func (p *Store) GetMetrics() *metrics.Metrics {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
 	getMetrics, err := p.metrics.GetMetrics()
 	if err != nil {
 		return nil
@@ -61,114 +95,242 @@ func (p *Store) GetMetrics() *metrics.Metrics {
 	return getMetrics
 }
 
+// Close closes every tracked object, persisting its pending state, and then the database
 func (p *Store) Close() error {
-	return p.DB.Close()
+	err := p.CloseAll()
+	p.unsubscribeAll()
+
+	p.stopSweeper()
+	<-p.swept
+
+	return errors.Join(err, p.DB.Close())
 }
 
+// Update runs fn in a write transaction, bbolt allows one at a time
 func (p *Store) Update(fn performAction) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.metrics.LastUpdate = time.Now()
-	p.metrics.Iops.TotalWrites++
+	p.metrics.UpdateIopsWrites()
 
 	return p.DB.Update(fn)
 }
 
+// View runs fn in a read transaction, reads run in parallel with each other and with the writer
 func (p *Store) View(fn performAction) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.metrics.LastUpdate = time.Now()
-	p.metrics.Iops.TotalReads++
+	p.metrics.UpdateIopsReads()
 
 	return p.DB.View(fn)
 }
 
+// Batch runs fn in a write transaction shared with the concurrent Batch calls, fn may run more than once
 func (p *Store) Batch(fn performAction) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.metrics.UpdateIopsWrites()
 
 	return p.DB.Batch(fn)
 }
 
+// DeleteBucket deletes the bucket at path with every bucket nested in it, the relations whose parent
+// is one of them are applied to the children of their keys
 func (p *Store) DeleteBucket(bucketName string) error {
-	return p.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(bucketName))
+	var events []Event
+	err := p.Update(func(tx *bolt.Tx) error {
+		var err error
+		events, err = p.deleteBucket(tx, bucketName)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+
+	p.publish(events...)
+	return nil
 }
 
+// DeleteKey deletes the key, the relations whose parent is the bucket are applied to its children
 func (p *Store) DeleteKey(bucketName string, key string) error {
-	return p.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
-			return nil
-		}
-		return bucket.Delete([]byte(key))
+	var events []Event
+	err := p.Update(func(tx *bolt.Tx) error {
+		var err error
+		events, err = p.deleteKey(tx, bucketName, key)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+
+	p.publish(events...)
+	return nil
 }
 
+// Put writes the value once the validators registered for the bucket accept it
 func (p *Store) Put(bucketName string, key string, value []byte) error {
-	return p.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return err
-		}
-
-		p.metrics.Iops.TotalWriteBytes += int64(len(value))
-
-		return bucket.Put([]byte(key), value)
-	})
+	_, err := p.PutMany([]Entry{{Bucket: bucketName, Key: key, Value: value}})
+	return err
 }
 
-func (p *Store) PutBatch(bucketName string, key string, values [][]byte) error {
-	return p.Batch(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return err
+// PutMany writes every entry in a single transaction, either all of them are written or none is.
+// It returns the new revision of each entry
+func (p *Store) PutMany(entries []Entry) ([]uint64, error) {
+	for _, entry := range entries {
+		if err := p.validate(entry.Bucket, entry.Key, entry.Value); err != nil {
+			return nil, err
 		}
-		for _, value := range values {
-			if err = bucket.Put([]byte(key), value); err != nil {
+	}
+
+	revs := make([]uint64, len(entries))
+	err := p.Update(func(tx *bolt.Tx) error {
+		for i, entry := range entries {
+			var err error
+			if revs[i], err = p.write(tx, entry); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.publishPuts(entries, revs)
+	return revs, nil
 }
 
+// write writes an entry inside a write transaction and returns its new revision, the entry must be validated
+func (p *Store) write(tx *bolt.Tx, entry Entry) (uint64, error) {
+	if err := p.check(tx, entry); err != nil {
+		return 0, err
+	}
+	return p.put(tx, entry.Bucket, entry.Key, entry.Value, entry.TTL)
+}
+
+// check returns the error writing an entry would fail with before anything of it is written, an
+// invalid path, a key or value bolt refuses, a revision conflict or a missing parent
+func (p *Store) check(tx *bolt.Tx, entry Entry) error {
+	names, err := splitPath(entry.Bucket)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case entry.Key == "":
+		return fmt.Errorf("bucket %s: %w", entry.Bucket, bolt.ErrKeyRequired)
+	case len(entry.Bucket)+len(entry.Key) > maxKeySize:
+		return fmt.Errorf("key of %d bytes in bucket %s: %w", len(entry.Key), entry.Bucket, bolt.ErrKeyTooLarge)
+	case int64(len(entry.Value)) > bolt.MaxValueSize:
+		return fmt.Errorf("key %s in bucket %s: %w", entry.Key, entry.Bucket, bolt.ErrValueTooLarge)
+	}
+
+	if err = checkNames(tx, names, entry.Key); err != nil {
+		return err
+	}
+
+	if entry.IfRevision {
+		if err := checkRevision(tx, entry.Bucket, entry.Key, entry.Revision); err != nil {
+			return err
+		}
+	}
+	return p.checkParents(tx, entry.Bucket, entry.Key, entry.Value)
+}
+
+// checkNames returns bolt.ErrIncompatibleValue if a name of the path is a key of its parent bucket,
+// or if the key is the name of a nested bucket
+func checkNames(tx *bolt.Tx, names [][]byte, key string) error {
+	bucket := tx.Bucket(names[0])
+	for _, name := range names[1:] {
+		if bucket == nil {
+			return nil
+		}
+
+		child := bucket.Bucket(name)
+		if child == nil && bucket.Get(name) != nil {
+			return fmt.Errorf("%w: %s is a key, not a bucket", bolt.ErrIncompatibleValue, name)
+		}
+		bucket = child
+	}
+
+	if bucket != nil && bucket.Bucket([]byte(key)) != nil {
+		return fmt.Errorf("%w: %s is a bucket, not a key", bolt.ErrIncompatibleValue, key)
+	}
+	return nil
+}
+
+// publishPuts publishes the events of entries written at revs
+func (p *Store) publishPuts(entries []Entry, revs []uint64) {
+	events := make([]Event, 0, len(entries))
+	for i, entry := range entries {
+		events = append(events, Event{Bucket: entry.Bucket, Key: entry.Key, Op: OpPut, Value: append([]byte(nil), entry.Value...), Revision: revs[i]})
+	}
+	p.publish(events...)
+}
+
+// put writes a value inside a write transaction and returns its new revision, the bucket and its
+// parents are created when missing. A ttl <= 0 removes the deadline the key may have had
+func (p *Store) put(tx *bolt.Tx, bucketName string, key string, value []byte, ttl time.Duration) (uint64, error) {
+	bucket, err := createBucket(tx, bucketName)
+	if err != nil {
+		return 0, err
+	}
+
+	p.metrics.AddWriteBytes(int64(len(value)))
+
+	if err = bucket.Put([]byte(key), value); err != nil {
+		return 0, err
+	}
+
+	if err = setExpiry(tx, bucketName, key, ttl); err != nil {
+		return 0, err
+	}
+
+	if err = p.link(tx, bucketName, key, value); err != nil {
+		return 0, err
+	}
+	return setRevision(tx, bucketName, key)
+}
+
+// Get returns a copy of the value of the key, nil if the key doesn't exist or has expired
 func (p *Store) Get(bucketName string, key string) ([]byte, error) {
 	var value []byte
 	err := p.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
+		if bucketOf(tx, bucketName) == nil {
 			value = []byte{}
 			return nil
 		}
-		value = bucket.Get([]byte(key))
+
+		value = bytes.Clone(lookup(tx, bucketName, key))
 		return nil
 	})
 	return value, err
 }
 
-func (p *Store) GetBucket(bucketName string) (*bolt.Bucket, error) {
-	var bucket *bolt.Bucket
-	err := p.View(func(tx *bolt.Tx) error {
-		bucket = tx.Bucket([]byte(bucketName))
-		return nil
+// ViewValue calls fn with the value of the key without copying it, nil if the key doesn't exist or
+// has expired. The value is only valid until fn returns and must not be modified, fn runs inside a
+// read transaction and must not write to the store
+func (p *Store) ViewValue(bucketName string, key string, fn func(value []byte) error) error {
+	return p.View(func(tx *bolt.Tx) error {
+		return fn(lookup(tx, bucketName, key))
 	})
-	return bucket, err
+}
+
+// lookup returns the value of the key inside a transaction, nil if it doesn't exist or has expired
+func lookup(tx *bolt.Tx, bucketName string, key string) []byte {
+	bucket := bucketOf(tx, bucketName)
+	if bucket == nil || isExpired(expiries(tx, bucketName), []byte(key), time.Now()) {
+		return nil
+	}
+	return bucket.Get([]byte(key))
 }
 
 func (p *Store) GetBucketKeys(bucketName string) ([]Key, error) {
-	var keys []Key
+	keys := make([]Key, 0)
 	err := p.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
+		bucket := bucketOf(tx, bucketName)
 		if bucket == nil {
-			keys = []Key{}
 			return nil
 		}
+
+		expiries, now := expiries(tx, bucketName), time.Now()
 		return bucket.ForEach(func(k, v []byte) error {
-			keys = append(keys, Key{Key: string(k)})
+			if v != nil && !isExpired(expiries, k, now) {
+				keys = append(keys, Key{Key: string(k)})
+			}
 			return nil
 		})
 	})
@@ -178,35 +340,55 @@ func (p *Store) GetBucketKeys(bucketName string) ([]Key, error) {
 func (p *Store) GetBucketValues(bucketName string) ([][]byte, error) {
 	var values [][]byte
 	err := p.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
+		bucket := bucketOf(tx, bucketName)
 		if bucket == nil {
 			return nil
 		}
+
+		expiries, now := expiries(tx, bucketName), time.Now()
 		return bucket.ForEach(func(k, v []byte) error {
-			values = append(values, v)
+			if v != nil && !isExpired(expiries, k, now) {
+				values = append(values, bytes.Clone(v))
+			}
 			return nil
 		})
 	})
 	return values, err
 }
 
+// GetBucketKeysValues returns copies of the keys and values of a bucket, they stay valid after the
+// read transaction and can be written back
 func (p *Store) GetBucketKeysValues(bucketName string) ([][]byte, [][]byte, error) {
 	var keys [][]byte
 	var values [][]byte
 	err := p.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
+		bucket := bucketOf(tx, bucketName)
 		if bucket == nil {
 			return nil
 		}
+
+		expiries, now := expiries(tx, bucketName), time.Now()
 		return bucket.ForEach(func(k, v []byte) error {
-			keys = append(keys, k)
-			values = append(values, v)
+			if v != nil && !isExpired(expiries, k, now) {
+				keys = append(keys, bytes.Clone(k))
+				values = append(values, bytes.Clone(v))
+			}
 			return nil
 		})
 	})
 	return keys, values, err
 }
 
+// CountKeys returns the number of keys in a bucket, the keys of its nested buckets aside
+func (p *Store) CountKeys(bucketName string) (int, error) {
+	var count int
+	err := p.View(func(tx *bolt.Tx) error {
+		count = countKeys(tx, bucketName)
+		return nil
+	})
+	return count, err
+}
+
 func (p *Store) PutObject(bucketName string, key string, w *WrapData) error {
 	data, err := json.Marshal(w)
 	if err != nil {
@@ -1,10 +1,14 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewPersistence(t *testing.T) {
@@ -28,15 +32,16 @@ func TestNewPersistence(t *testing.T) {
 
 	fmt.Printf("Object: %s\n", data)
 
-	// batch insert
-	values := make([][]byte, 100)
+	// bulk insert
+	entries := make([]Entry, 0, 100)
 
 	for i := 0; i < 100; i++ {
-		values = append(values, []byte(fmt.Sprintf("value %d", i)))
+		entries = append(entries, Entry{Bucket: movie, Key: fmt.Sprintf("%s %d", key, i), Value: []byte(fmt.Sprintf("value %d", i))})
 	}
 
-	err = per.PutBatch(movie, key, values)
-	assert.NoErrorf(t, err, "error putting batch key/value pair")
+	report, err := per.Ingest(entries, IngestOptions{})
+	assert.NoErrorf(t, err, "error ingesting key/value pairs")
+	assert.Equal(t, 100, report.Written)
 
 	// getting value from key
 	obj, err := per.GetBucketValues(movie)
@@ -47,6 +52,254 @@ func TestNewPersistence(t *testing.T) {
 	}
 }
 
+func TestStore_RegisterValidator(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "validator.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	errEmpty := errors.New("empty value")
+	per.RegisterValidator("movies", func(key string, value []byte) error {
+		if len(value) == 0 {
+			return errEmpty
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, per.Put("movies", "Rogers", nil), errEmpty)
+	assert.NoError(t, per.Put("movies", "Rogers", []byte("Avengers, Assemble!")))
+	assert.NoError(t, per.Put("series", "Rogers", nil), "validator applied to another bucket")
+}
+
+func TestStore_Subscribe(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "subscribe.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	events := make(chan Event, 10)
+	cancel := per.Subscribe("movies", "Rogers", func(event Event) {
+		events <- event
+	})
+
+	assert.NoError(t, per.Put("movies", "Stark", []byte("I am Iron Man")))
+	assert.NoError(t, per.Put("movies", "Rogers", []byte("Avengers, Assemble!")))
+	assert.NoError(t, per.DeleteKey("movies", "Rogers"))
+
+	event := <-events
+	assert.Equal(t, Event{Bucket: "movies", Key: "Rogers", Op: OpPut, Value: []byte("Avengers, Assemble!"), Revision: 2}, event)
+	assert.Equal(t, OpDelete, (<-events).Op)
+
+	cancel()
+	assert.NoError(t, per.Put("movies", "Rogers", []byte("Avengers, Assemble!")))
+	assert.Empty(t, events)
+
+	bucketEvents := make(chan Event, 10)
+	per.Subscribe("movies", "", func(event Event) {
+		bucketEvents <- event
+	})
+	keyEvents := make(chan Event, 10)
+	per.Subscribe("movies", "Stark", func(event Event) {
+		keyEvents <- event
+	})
+
+	assert.NoError(t, per.Put("movies", "Stark", []byte("I am Iron Man")))
+	assert.NoError(t, per.DeleteBucket("movies"))
+
+	assert.Equal(t, "Stark", (<-bucketEvents).Key)
+	assert.Equal(t, "Stark", (<-keyEvents).Key)
+
+	// the delete of the bucket goes to the subscriptions of its keys as well
+	assert.Equal(t, Event{Bucket: "movies", Op: OpDelete, Revision: 6}, <-bucketEvents)
+	assert.Equal(t, Event{Bucket: "movies", Op: OpDelete, Revision: 6}, <-keyEvents)
+}
+
+func TestStore_PutIfRevision(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "revision.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	rev, err := per.PutIfRevision("movies", "Rogers", []byte("Avengers, Assemble!"), 0)
+	assert.NoError(t, err, "error creating key")
+
+	_, err = per.PutIfRevision("movies", "Rogers", []byte("I can do this all day"), 0)
+	var conflict *ConflictError
+	assert.ErrorAs(t, err, &conflict)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, rev, conflict.Actual)
+
+	next, err := per.PutIfRevision("movies", "Rogers", []byte("I can do this all day"), rev)
+	assert.NoError(t, err, "error updating key")
+	assert.Greater(t, next, rev, "revision did not increase")
+
+	value, current, err := per.GetWithRevision("movies", "Rogers")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("I can do this all day"), value)
+	assert.Equal(t, next, current)
+
+	assert.ErrorIs(t, per.DeleteIfRevision("movies", "Rogers", rev), ErrConflict)
+	assert.NoError(t, per.DeleteIfRevision("movies", "Rogers", next))
+
+	current, err = per.GetRevision("movies", "Rogers")
+	assert.NoError(t, err)
+	assert.Zero(t, current, "revision kept after delete")
+
+	// revisions are never reused, not even after a delete
+	recreated, err := per.PutIfRevision("movies", "Rogers", []byte("Avengers, Assemble!"), 0)
+	assert.NoError(t, err, "error recreating key")
+	assert.Greater(t, recreated, next)
+}
+
+func TestStore_PutWithTTL(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "ttl.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.PutWithTTL("sessions", "Rogers", []byte("token"), 20*time.Millisecond))
+	assert.NoError(t, per.PutWithTTL("sessions", "Stark", []byte("token"), time.Hour))
+	assert.NoError(t, per.Put("sessions", "Banner", []byte("token")))
+
+	value, err := per.Get("sessions", "Rogers")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("token"), value)
+
+	ttl, err := per.GetTTL("sessions", "Stark")
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	time.Sleep(30 * time.Millisecond)
+
+	// expired keys are hidden before the sweeper runs
+	value, err = per.Get("sessions", "Rogers")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	keys, err := per.GetBucketKeys("sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, []Key{{Key: "Banner"}, {Key: "Stark"}}, keys)
+
+	count, err := per.CountKeys("sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	events := make(chan Event, 10)
+	per.Subscribe("sessions", "", func(event Event) {
+		events <- event
+	})
+
+	swept, err := per.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept)
+	assert.Equal(t, OpDelete, (<-events).Op)
+
+	// a plain write removes the lifetime
+	assert.NoError(t, per.Put("sessions", "Stark", []byte("token")))
+	ttl, err = per.GetTTL("sessions", "Stark")
+	assert.NoError(t, err)
+	assert.Zero(t, ttl)
+}
+
+func TestStore_ViewValue(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "view.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(t, per.Put("movies", "Rogers", []byte("Avengers, Assemble!")))
+
+	// the value is owned by the caller
+	value, err := per.Get("movies", "Rogers")
+	assert.NoError(t, err)
+	value[0] = 'a'
+
+	value, err = per.Get("movies", "Rogers")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Avengers, Assemble!"), value)
+
+	var length int
+	assert.NoError(t, per.ViewValue("movies", "Rogers", func(value []byte) error {
+		length = len(value)
+		return nil
+	}))
+	assert.Equal(t, 19, length)
+
+	assert.NoError(t, per.ViewValue("movies", "Stark", func(value []byte) error {
+		assert.Nil(t, value)
+		return nil
+	}))
+
+	errStop := errors.New("stop")
+	assert.ErrorIs(t, per.ViewValue("movies", "Rogers", func([]byte) error {
+		return errStop
+	}), errStop)
+}
+
+func TestStore_GetBucketKeysValues(t *testing.T) {
+	per, err := NewStore(context.TODO(), filepath.Join(t.TempDir(), "keys.db"))
+	assert.NoErrorf(t, err, "error creating store")
+	defer per.Close()
+
+	for i := range 100 {
+		assert.NoError(t, per.Put("movies", fmt.Sprintf("movie-%03d", i), []byte("value")))
+	}
+
+	keys, values, err := per.GetBucketKeysValues("movies")
+	assert.NoError(t, err)
+	assert.Len(t, values, 100)
+
+	// the keys are still valid once the writes grow and remap the database
+	for i, key := range keys {
+		assert.NoError(t, per.Put("movies", string(key), bytes.Repeat([]byte("value"), 10_000)))
+		assert.Equal(t, fmt.Sprintf("movie-%03d", i), string(key))
+	}
+}
+
+// BenchmarkStore_ViewValue reads like BenchmarkStore_Get without copying the values
+func BenchmarkStore_ViewValue(b *testing.B) {
+	per, err := NewStore(context.TODO(), filepath.Join(b.TempDir(), "bench.db"))
+	assert.NoErrorf(b, err, "error creating store")
+	defer per.Close()
+
+	assert.NoError(b, per.Put("movies", "Rogers", bytes.Repeat([]byte("Avengers, Assemble!"), 100)))
+
+	var length int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = per.ViewValue("movies", "Rogers", func(value []byte) error {
+			length += len(value)
+			return nil
+		})
+	}
+}
+
+// BenchmarkStore_Get reads from every goroutine at once, run it with -cpu 1,2,4,8 to see
+// the read throughput scale with the cores
+func BenchmarkStore_Get(b *testing.B) {
+	per, err := NewStore(context.TODO(), filepath.Join(b.TempDir(), "bench.db"))
+	assert.NoErrorf(b, err, "error creating store")
+	defer per.Close()
+
+	keys := make([]string, 1000)
+	entries := make([]Entry, len(keys))
+	for i := range keys {
+		keys[i] = GenerateKey()
+		entries[i] = Entry{Bucket: "movies", Key: keys[i], Value: []byte("Avengers, Assemble!")}
+	}
+
+	_, err = per.PutMany(entries)
+	assert.NoError(b, err)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := per.Get("movies", keys[i%len(keys)]); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
 //func testDBAction(t *testing.T, action func(*Store) error) {
 //	tmpDir, _ := os.MkdirTemp("", "prefix")
 //	defer os.Remove(tmpDir) // clean up
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	// expiresBucket keeps the deadline of every key written with a ttl, in a nested bucket per
+	// data bucket, reads use it to hide expired keys before they are swept
+	expiresBucket = "__expires"
+
+	// expiryBucket indexes the keys by deadline so the sweeper only visits expired keys,
+	// entries are the deadline followed by the bucket and the key
+	expiryBucket = "__expiry"
+
+	sweepInterval = 30 * time.Second
+	sweepBatch    = 1000
+)
+
+// PutWithTTL writes the value like Put, the key expires after ttl. Reads return nothing for
+// an expired key and the sweeper deletes it, a ttl <= 0 writes a key that never expires
+func (p *Store) PutWithTTL(bucketName string, key string, value []byte, ttl time.Duration) error {
+	_, err := p.PutMany([]Entry{{Bucket: bucketName, Key: key, Value: value, TTL: ttl}})
+	return err
+}
+
+// GetTTL returns the time left before the key expires, 0 if it doesn't expire or has expired
+func (p *Store) GetTTL(bucketName string, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := p.View(func(tx *bolt.Tx) error {
+		if deadline, ok := deadlineOf(expiries(tx, bucketName), key); ok {
+			ttl = max(time.Until(deadline), 0)
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// Sweep deletes the keys that have expired and returns how many were deleted, it runs in
+// the background every 30 seconds while the store is open. An expired key still referenced
+// through a Restrict relation stays hidden and is deleted by the first sweep after its children
+func (p *Store) Sweep() (int, error) {
+	var (
+		count int
+		after []byte
+	)
+
+	for {
+		events, last, err := p.sweep(time.Now(), after)
+		if err != nil {
+			return count, err
+		}
+
+		for _, event := range events {
+			if event.Op == OpDelete {
+				count++
+			}
+		}
+		p.publish(events...)
+
+		if last == nil {
+			return count, nil
+		}
+		after = last
+	}
+}
+
+// sweep visits up to sweepBatch index entries expired at now that follow after in a single
+// transaction, it returns the events of the keys deleted and the last entry visited, nil once
+// every expired entry was visited
+func (p *Store) sweep(now time.Time, after []byte) ([]Event, []byte, error) {
+	var (
+		events []Event
+		due    [][]byte
+	)
+
+	err := p.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(expiryBucket))
+		if index == nil {
+			return nil
+		}
+
+		cursor := index.Cursor()
+
+		k, _ := cursor.First()
+		if after != nil {
+			if k, _ = cursor.Seek(after); bytes.Equal(k, after) {
+				k, _ = cursor.Next()
+			}
+		}
+
+		for ; k != nil && len(due) < sweepBatch; k, _ = cursor.Next() {
+			if len(k) < 8 || int64(binary.BigEndian.Uint64(k)) > now.UnixNano() {
+				break
+			}
+			due = append(due, append([]byte(nil), k...))
+		}
+
+		for _, k := range due {
+			bucketName, key, ok := parseExpiryKey(k)
+
+			// the key was written again or deleted since, the index entry is stale
+			if deadline, found := deadlineOf(expiries(tx, bucketName), key); !ok || !found || deadline.UnixNano() != int64(binary.BigEndian.Uint64(k)) {
+				if err := index.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// the entry is kept, the key is visited again on the next sweep
+			err := p.checkDelete(tx, bucketName, key, map[string]bool{})
+			if errors.Is(err, ErrRestricted) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			deleted, err := p.removeKey(tx, bucketName, key)
+			if err != nil {
+				return err
+			}
+			events = append(events, deleted...)
+		}
+		return nil
+	})
+	if err != nil || len(due) < sweepBatch {
+		return events, nil, err
+	}
+	return events, due[len(due)-1], nil
+}
+
+// sweeper runs Sweep every interval until ctx is done
+func (p *Store) sweeper(ctx context.Context, interval time.Duration) {
+	defer close(p.swept)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// expired keys stay hidden from reads, a failed sweep is attempted again on the next tick
+			_, _ = p.Sweep()
+		}
+	}
+}
+
+// setExpiry records the deadline of a key, a ttl <= 0 removes it
+func setExpiry(tx *bolt.Tx, bucketName string, key string, ttl time.Duration) error {
+	if err := clearExpiry(tx, bucketName, key); err != nil || ttl <= 0 {
+		return err
+	}
+
+	deadline := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(ttl).UnixNano()))
+
+	expires, err := tx.CreateBucketIfNotExists([]byte(expiresBucket))
+	if err != nil {
+		return err
+	}
+
+	bucket, err := expires.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+
+	if err = bucket.Put([]byte(key), deadline); err != nil {
+		return err
+	}
+
+	index, err := tx.CreateBucketIfNotExists([]byte(expiryBucket))
+	if err != nil {
+		return err
+	}
+	return index.Put(expiryKey(deadline, bucketName, key), nil)
+}
+
+// clearExpiry removes the deadline of a key and its index entry
+func clearExpiry(tx *bolt.Tx, bucketName string, key string) error {
+	bucket := expiries(tx, bucketName)
+	if bucket == nil {
+		return nil
+	}
+
+	deadline := bucket.Get([]byte(key))
+	if deadline == nil {
+		return nil
+	}
+
+	if index := tx.Bucket([]byte(expiryBucket)); index != nil {
+		if err := index.Delete(expiryKey(deadline, bucketName, key)); err != nil {
+			return err
+		}
+	}
+	return bucket.Delete([]byte(key))
+}
+
+// expiries returns the deadlines of the keys of a bucket, nil if none of them expires
+func expiries(tx *bolt.Tx, bucketName string) *bolt.Bucket {
+	expires := tx.Bucket([]byte(expiresBucket))
+	if expires == nil {
+		return nil
+	}
+	return expires.Bucket([]byte(bucketName))
+}
+
+// deadlineOf returns the deadline of a key in the deadlines of its bucket
+func deadlineOf(expiries *bolt.Bucket, key string) (time.Time, bool) {
+	if expiries == nil {
+		return time.Time{}, false
+	}
+
+	data := expiries.Get([]byte(key))
+	if len(data) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), true
+}
+
+// isExpired reports whether the key has expired at now
+func isExpired(expiries *bolt.Bucket, key []byte, now time.Time) bool {
+	deadline, ok := deadlineOf(expiries, string(key))
+	return ok && !deadline.After(now)
+}
+
+// expiryKey returns the index entry of a key, the bucket name is length prefixed
+func expiryKey(deadline []byte, bucketName string, key string) []byte {
+	k := make([]byte, 0, len(deadline)+binary.MaxVarintLen64+len(bucketName)+len(key))
+	k = append(k, deadline...)
+	k = binary.AppendUvarint(k, uint64(len(bucketName)))
+	k = append(k, bucketName...)
+	return append(k, key...)
+}
+
+// parseExpiryKey returns the bucket and the key of an index entry
+func parseExpiryKey(k []byte) (string, string, bool) {
+	rest := k[8:]
+
+	n, size := binary.Uvarint(rest)
+	if size <= 0 || uint64(len(rest)-size) < n {
+		return "", "", false
+	}
+
+	rest = rest[size:]
+	return string(rest[:n]), string(rest[n:]), true
+}
@@ -0,0 +1,27 @@
+package store
+
+type (
+	// ValueValidator checks a value before it is written to a bucket, a non-nil error blocks the write
+	ValueValidator func(key string, value []byte) error
+)
+
+// RegisterValidator adds a validator run on every value written to bucketName
+func (p *Store) RegisterValidator(bucketName string, fn ValueValidator) {
+	p.validatorsMu.Lock()
+	defer p.validatorsMu.Unlock()
+
+	p.validators[bucketName] = append(p.validators[bucketName], fn)
+}
+
+// validate runs the validators registered for bucketName
+func (p *Store) validate(bucketName string, key string, value []byte) error {
+	p.validatorsMu.RLock()
+	defer p.validatorsMu.RUnlock()
+
+	for _, fn := range p.validators[bucketName] {
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"strings"
+)
+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+type (
+	// Validator is implemented by objects that check themselves before they are persisted
+	Validator interface {
+		Validate() error
+	}
+
+	// FieldError describes a field that failed validation
+	FieldError struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// Error is returned when an object or value fails validation, it lists every failed field
+	Error struct {
+		Fields []FieldError `json:"fields"`
+	}
+)
+
+func (e *Error) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", field.Field, field.Message))
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// NewError creates a validation error for a single field
+func NewError(field, message string) *Error {
+	return &Error{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+// Validate checks the `validate` struct tags of v and then calls Validate if v implements Validator
+func Validate(v any) error {
+	if err := Struct(v); err != nil {
+		return err
+	}
+
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// Struct checks the `validate` struct tags of v, values that aren't structs are always valid
+func Struct(v any) error {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	result := &Error{Fields: make([]FieldError, 0, len(errs))}
+	for _, fe := range errs {
+		rule := fe.Tag()
+		if fe.Param() != "" {
+			rule += "=" + fe.Param()
+		}
+
+		result.Fields = append(result.Fields, FieldError{
+			Field:   fieldPath(fe.StructNamespace()),
+			Rule:    rule,
+			Message: fmt.Sprintf("failed on the %s rule", rule),
+		})
+	}
+	return result
+}
+
+// fieldPath removes the struct name from the namespace, Order.Customer.Email becomes Customer.Email
+func fieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
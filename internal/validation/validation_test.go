@@ -0,0 +1,46 @@
+package validation
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type address struct {
+	City string `validate:"required"`
+}
+
+type customer struct {
+	Name    string `validate:"required"`
+	Email   string `validate:"required,email"`
+	Age     int    `validate:"gte=18"`
+	Address address
+}
+
+func (c *customer) Validate() error {
+	if c.Name == "root" {
+		return NewError("Name", "is reserved")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate(&customer{Name: "John", Email: "john", Age: 12})
+
+	var validationErr *Error
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []FieldError{
+		{Field: "Email", Rule: "email", Message: "failed on the email rule"},
+		{Field: "Age", Rule: "gte=18", Message: "failed on the gte=18 rule"},
+		{Field: "Address.City", Rule: "required", Message: "failed on the required rule"},
+	}, validationErr.Fields)
+
+	valid := customer{Name: "John", Email: "john@example.com", Age: 42, Address: address{City: "Lisbon"}}
+	assert.NoError(t, Validate(&valid))
+
+	valid.Name = "root"
+	assert.True(t, errors.As(Validate(&valid), &validationErr))
+	assert.Equal(t, "Name", validationErr.Fields[0].Field)
+
+	assert.NoError(t, Validate("not a struct"))
+}